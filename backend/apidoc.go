@@ -0,0 +1,246 @@
+package main
+
+import (
+	"net/http"
+	"space-travel/calculations"
+	"space-travel/database"
+	"space-travel/openapi"
+	"space-travel/scheduler"
+	"space-travel/structs"
+)
+
+// What clients send to book or hold an itinerary, the rest of structs.Booking is computed from the pricelist
+type bookingRequest struct {
+	PricelistID string              `json:"pricelistID"`
+	ProviderIDs []string            `json:"providerIDs"`
+	Passengers  []structs.Passenger `json:"passengers,omitempty"`
+	// Name a single passenger instead of listing passengers
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	// Checked against the computed price when sent
+	TotalPrice float64            `json:"totalPrice,omitempty"`
+	Return     *returnTripRequest `json:"return,omitempty"`
+}
+
+type returnTripRequest struct {
+	ProviderIDs []string `json:"providerIDs"`
+}
+
+type confirmHoldRequest struct {
+	Passengers []structs.Passenger `json:"passengers,omitempty"`
+}
+
+// Describes every endpoint of the API, with the schemas taken from the types the handlers encode
+func apiDocument() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "Space Travel API",
+		Version:     "1.0",
+		Description: "Routes, holds and bookings between the planets of the latest Cosmos Odyssey pricelist",
+	})
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"adminToken": {Type: "http", Scheme: "bearer"},
+	}
+
+	str := &openapi.Schema{Type: "string"}
+	integer := &openapi.Schema{Type: "integer"}
+	number := &openapi.Schema{Type: "number"}
+	boolean := &openapi.Schema{Type: "boolean"}
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	enum := func(values ...string) *openapi.Schema {
+		return &openapi.Schema{Type: "string", Enum: values}
+	}
+	path := func(name string, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "path", Description: description, Required: true, Schema: str}
+	}
+	query := func(name string, schema *openapi.Schema, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+	}
+	ok := func(description string, value any) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(doc.Schema(value))}
+	}
+	errorSchema := doc.Schema(errorResponse{})
+	fail := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(errorSchema)}
+	}
+	body := func(value any) *openapi.RequestBody {
+		return &openapi.RequestBody{Required: true, Content: openapi.JSON(doc.Schema(value))}
+	}
+	// Booking requests can be retried safely with an Idempotency-Key
+	idempotent := func(operation openapi.Operation) openapi.Operation {
+		operation.Parameters = append(operation.Parameters, openapi.Parameter{
+			Name: "Idempotency-Key", In: "header", Schema: str,
+			Description: "Repeating a request with the same key returns the first response instead of booking twice",
+		})
+		success := operation.Responses["200"]
+		success.Headers = map[string]openapi.Header{
+			"Idempotent-Replayed": {Description: "Set to true on a replayed response", Schema: str},
+		}
+		operation.Responses["200"] = success
+		operation.Responses["409"] = fail("sold_out, hold_confirmed or request_in_progress")
+		operation.Responses["422"] = fail("idempotency_key_reused")
+		return operation
+	}
+
+	searchParameters := []openapi.Parameter{
+		path("from", "Departure planet"),
+		path("destination", "Destination planet"),
+		query("mode", enum(string(calculations.EarliestArrivalMode), string(calculations.ParetoMode)), "earliest (default) or pareto"),
+		query("minConnection", str, "Shortest wait between two legs, for example 45m"),
+		query("maxLayover", str, "Longest wait between two legs, for example 48h"),
+		query("passengers", integer, "Seats needed, 1 by default"),
+		query("includeSoldOut", boolean, "Keep options with too few seats left, flagged with soldOut"),
+		query("limit", integer, "Most options returned"),
+		query("offset", integer, "Options skipped"),
+	}
+	routeParameters := append(searchParameters[:len(searchParameters):len(searchParameters)],
+		query("via", str, "Planets to pass through in order, each with an optional minimum stay, for example Jupiter:48h,Mars"),
+		query("sort", enum("price", "duration", "departure", "arrival"), ""),
+		query("order", enum("asc", "desc"), ""),
+		query("company", str, "Keep options using these companies, by name or ID, comma separated"),
+		query("excludeCompany", str, "Drop options using these companies, by name or ID, comma separated"),
+		query("maxPrice", number, ""),
+		query("maxDuration", str, "For example 36h30m"),
+		query("departAfter", dateTime, ""),
+		query("departBefore", dateTime, ""),
+	)
+	searchErrors := map[string]openapi.Response{
+		"400": fail("invalid_planet or invalid_parameter"),
+		"404": fail("no_providers"),
+		"500": fail("internal_error"),
+		"503": fail("no_pricelist"),
+	}
+	withErrors := func(responses map[string]openapi.Response, errors map[string]openapi.Response) map[string]openapi.Response {
+		for status, response := range errors {
+			responses[status] = response
+		}
+		return responses
+	}
+
+	doc.Add(http.MethodGet, "/api/get/{from}/{destination}", openapi.Operation{
+		Summary:     "Routes between two planets in the latest pricelist",
+		OperationID: "searchRoutes",
+		Parameters:  routeParameters,
+		Responses:   withErrors(map[string]openapi.Response{"200": ok("Matching routes", structs.GetResponse{})}, searchErrors),
+	})
+	doc.Add(http.MethodGet, "/api/roundtrip/{from}/{destination}", openapi.Operation{
+		Summary:     "Outbound and return options from the same pricelist, cheapest first",
+		OperationID: "searchRoundTrips",
+		Parameters: append(searchParameters[:len(searchParameters):len(searchParameters)],
+			query("returnFrom", str, "Departure planet of the return, the destination by default"),
+			query("returnTo", str, "Destination planet of the return, from by default"),
+			query("minStay", str, "Shortest stay between landing and the return departure, for example 72h"),
+		),
+		Responses: withErrors(map[string]openapi.Response{"200": ok("Matching round trips", structs.RoundTripResponse{})}, searchErrors),
+	})
+	doc.Add(http.MethodPost, "/api/post", idempotent(openapi.Operation{
+		Summary:     "Book an itinerary",
+		OperationID: "createBooking",
+		RequestBody: body(bookingRequest{}),
+		Responses: map[string]openapi.Response{
+			"200": ok("The stored booking", structs.Booking{}),
+			"400": fail("malformed_json, validation_failed or invalid_idempotency_key"),
+			"410": fail("pricelist_expired"),
+			"500": fail("internal_error"),
+		},
+	}))
+	doc.Add(http.MethodPost, "/api/holds", idempotent(openapi.Operation{
+		Summary:     "Keep the seats and price of an itinerary for a while",
+		OperationID: "createHold",
+		RequestBody: body(bookingRequest{}),
+		Responses: map[string]openapi.Response{
+			"200": ok("The new hold", structs.Hold{}),
+			"400": fail("malformed_json, validation_failed or invalid_idempotency_key"),
+			"410": fail("pricelist_expired"),
+			"500": fail("internal_error"),
+		},
+	}))
+	doc.Add(http.MethodGet, "/api/holds/{reference}", openapi.Operation{
+		Summary:     "A hold, with the booking reference once it is confirmed",
+		OperationID: "getHold",
+		Parameters:  []openapi.Parameter{path("reference", "")},
+		Responses: map[string]openapi.Response{
+			"200": ok("The hold", structs.Hold{}),
+			"404": fail("hold_not_found"),
+			"500": fail("internal_error"),
+		},
+	})
+	confirm := idempotent(openapi.Operation{
+		Summary:     "Book a hold at the held price",
+		OperationID: "confirmHold",
+		Parameters:  []openapi.Parameter{path("reference", "")},
+		RequestBody: body(confirmHoldRequest{}),
+		Responses: map[string]openapi.Response{
+			"200": ok("The stored booking", structs.Booking{}),
+			"400": fail("malformed_json, validation_failed or invalid_idempotency_key"),
+			"404": fail("hold_not_found"),
+			"410": fail("hold_expired"),
+			"500": fail("internal_error"),
+		},
+	})
+	// A hold made with the passenger names is confirmed without a body
+	confirm.RequestBody.Required = false
+	doc.Add(http.MethodPost, "/api/holds/{reference}/confirm", confirm)
+	doc.Add(http.MethodGet, "/api/bookings", openapi.Operation{
+		Summary:     "Bookings for staff, newest first",
+		OperationID: "listBookings",
+		Parameters: []openapi.Parameter{
+			query("passenger", str, "Part of the name of any passenger, in any case"),
+			query("from", str, ""),
+			query("destination", str, ""),
+			query("company", str, ""),
+			query("date", &openapi.Schema{Type: "string", Format: "date"}, "Departure day"),
+			query("status", enum(database.BookingConfirmed, database.BookingCancelled), ""),
+			query("limit", integer, "Most bookings returned, 50 by default and at most 500"),
+			query("offset", integer, "Bookings skipped"),
+		},
+		Security: []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openapi.Response{
+			"200": ok("Matching bookings", structs.BookingList{}),
+			"400": fail("invalid_parameter"),
+			"401": fail("unauthorized"),
+			"500": fail("internal_error"),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/bookings/{reference}", openapi.Operation{
+		Summary:     "A booking",
+		OperationID: "getBooking",
+		Parameters:  []openapi.Parameter{path("reference", "")},
+		Security:    []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openapi.Response{
+			"200": ok("The booking", structs.Booking{}),
+			"401": fail("unauthorized"),
+			"404": fail("booking_not_found"),
+			"500": fail("internal_error"),
+		},
+	})
+	doc.Add(http.MethodDelete, "/api/bookings/{reference}", openapi.Operation{
+		Summary:     "Cancel a booking, which is kept with status cancelled",
+		OperationID: "cancelBooking",
+		Parameters:  []openapi.Parameter{path("reference", "")},
+		Security:    []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openapi.Response{
+			"200": ok("The cancelled booking", structs.Booking{}),
+			"401": fail("unauthorized"),
+			"404": fail("booking_not_found"),
+			"409": fail("booking_cancelled"),
+			"500": fail("internal_error"),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/admin/status", openapi.Operation{
+		Summary:     "State of the pricelist fetcher",
+		OperationID: "fetcherStatus",
+		Security:    []map[string][]string{{"adminToken": {}}},
+		Responses: map[string]openapi.Response{
+			"200": ok("Fetcher state", scheduler.Status{}),
+			"401": fail("unauthorized"),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/openapi.json", openapi.Operation{
+		Summary:     "This document",
+		OperationID: "openAPIDocument",
+		Responses: map[string]openapi.Response{
+			"200": {Description: "OpenAPI 3.0 document", Content: openapi.JSON(&openapi.Schema{Type: "object"})},
+		},
+	})
+	return doc
+}
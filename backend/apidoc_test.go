@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"space-travel/config"
+	"space-travel/database"
+	"space-travel/openapi"
+	"space-travel/scheduler"
+	"space-travel/structs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A pricelist with a few flights from Earth to Mars and back, all of them in the future
+func testPricelist(now time.Time) structs.Pricelist {
+	earth := structs.Location{ID: "earth", Name: "Earth"}
+	mars := structs.Location{ID: "mars", Name: "Mars"}
+	company := structs.Company{ID: "company", Name: "Space Piper"}
+	leg := func(id string, from structs.Location, to structs.Location, departure time.Time) structs.Leg {
+		leg := structs.Leg{
+			ID:        id,
+			RouteInfo: structs.RouteInfo{ID: id + "-route", From: from, To: to, Distance: 54600000},
+		}
+		for i := 0; i < 3; i++ {
+			start := departure.Add(time.Duration(i) * 24 * time.Hour)
+			leg.Providers = append(leg.Providers, structs.Provider{
+				ID:          fmt.Sprintf("%s-provider-%d", id, i),
+				Company:     company,
+				Price:       100 + float64(i)*10.5,
+				FlightStart: start,
+				FlightEnd:   start.Add(26 * time.Hour),
+			})
+		}
+		return leg
+	}
+	return structs.Pricelist{
+		ID:         "pricelist",
+		ValidUntil: now.Add(time.Hour),
+		Legs: []structs.Leg{
+			leg("outbound", earth, mars, now.Add(time.Hour)),
+			leg("inbound", mars, earth, now.Add(10*24*time.Hour)),
+		},
+	}
+}
+
+// Calls every documented operation on a router over the memory store and checks that the status
+// is documented for the operation and that the body matches the schema documented for it
+func TestResponsesMatchAPIDocument(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	store := database.NewMemoryStore()
+	if err := store.InsertPricelist(testPricelist(now)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Load()
+	cfg.AdminToken = "staff"
+	fetcher := scheduler.New(scheduler.Config{}, func() (time.Time, error) {
+		return now.Add(time.Hour), nil
+	})
+	router, err := newRouter(store, cfg, fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := apiDocument()
+
+	called := map[string]bool{}
+	call := func(method string, path string, target string, body string, header http.Header, wantStatus int) map[string]any {
+		t.Helper()
+		operation, ok := doc.Paths[path][strings.ToLower(method)]
+		if !ok {
+			t.Fatalf("%s %s is not documented", method, path)
+		}
+		called[method+" "+path] = true
+
+		if body != "" && operation.RequestBody != nil {
+			var sent any
+			if err := json.Unmarshal([]byte(body), &sent); err == nil {
+				for _, problem := range checkSchema(doc, operation.RequestBody.Content["application/json"].Schema, sent, "request") {
+					t.Errorf("%s %s: %s", method, target, problem)
+				}
+			}
+		}
+
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != wantStatus {
+			t.Fatalf("%s %s answered %d, want %d: %s", method, target, w.Code, wantStatus, w.Body)
+		}
+		response, ok := operation.Responses[fmt.Sprint(w.Code)]
+		if !ok {
+			t.Fatalf("%s %s answered %d, which is not documented", method, target, w.Code)
+		}
+		if _, ok := response.Headers["Idempotent-Replayed"]; w.Header().Get("Idempotent-Replayed") != "" && !ok {
+			t.Errorf("%s %s sent Idempotent-Replayed, which is not documented", method, target)
+		}
+		var decoded any
+		if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s %s answered with invalid JSON: %v", method, target, err)
+		}
+		for _, problem := range checkSchema(doc, response.Content["application/json"].Schema, decoded, "response") {
+			t.Errorf("%s %s answered %d: %s", method, target, w.Code, problem)
+		}
+		object, _ := decoded.(map[string]any)
+		return object
+	}
+	withKey := func(key string) http.Header {
+		return http.Header{"Idempotency-Key": {key}}
+	}
+
+	call("GET", "/api/get/{from}/{destination}", "/api/get/Earth/Mars", "", nil, 200)
+	call("GET", "/api/get/{from}/{destination}", "/api/get/Earth/Pluto", "", nil, 400)
+	call("GET", "/api/get/{from}/{destination}", "/api/get/Earth/Mars?limit=x", "", nil, 400)
+	call("GET", "/api/roundtrip/{from}/{destination}", "/api/roundtrip/Earth/Mars?minStay=24h", "", nil, 200)
+	call("GET", "/api/roundtrip/{from}/{destination}", "/api/roundtrip/Earth/Mars?sort=price", "", nil, 400)
+
+	booking := `{"pricelistID":"pricelist","providerIDs":["outbound-provider-0"],` +
+		`"passengers":[{"firstName":"Ada","lastName":"Lovelace","dateOfBirth":"1815-12-10"}],` +
+		`"return":{"providerIDs":["inbound-provider-0"]}}`
+	booked := call("POST", "/api/post", "/api/post", booking, withKey("first"), 200)
+	call("POST", "/api/post", "/api/post", booking, withKey("first"), 200)
+	call("POST", "/api/post", "/api/post", `{"pricelistID":"pricelist","providerIDs":["outbound-provider-1"],"firstName":"Ada","lastName":"Lovelace"}`, withKey("first"), 422)
+	call("POST", "/api/post", "/api/post", `{"pricelistID":`, nil, 400)
+	call("POST", "/api/post", "/api/post", `{"pricelistID":"pricelist","providerIDs":["outbound-provider-0"]}`, nil, 400)
+
+	// Names can be left empty until the hold is confirmed
+	unnamed := `{"firstName":"","lastName":""}`
+	held := call("POST", "/api/holds", "/api/holds", `{"pricelistID":"pricelist","providerIDs":["outbound-provider-1"],"passengers":[`+unnamed+`,`+unnamed+`]}`, nil, 200)
+	hold := fmt.Sprint(held["reference"])
+	call("GET", "/api/holds/{reference}", "/api/holds/"+hold, "", nil, 200)
+	call("GET", "/api/holds/{reference}", "/api/holds/UNKNOWN", "", nil, 404)
+	call("POST", "/api/holds/{reference}/confirm", "/api/holds/"+hold+"/confirm", `{"passengers":[{"firstName":"Ada","lastName":"Lovelace"}]}`, nil, 400)
+	passengers := `{"passengers":[{"firstName":"Ada","lastName":"Lovelace"},{"firstName":"Charles","lastName":"Babbage"}]}`
+	call("POST", "/api/holds/{reference}/confirm", "/api/holds/"+hold+"/confirm", passengers, nil, 200)
+	call("POST", "/api/holds/{reference}/confirm", "/api/holds/"+hold+"/confirm", passengers, nil, 409)
+	call("POST", "/api/holds/{reference}/confirm", "/api/holds/UNKNOWN/confirm", passengers, nil, 404)
+
+	reference := fmt.Sprint(booked["reference"])
+	staff := http.Header{"Authorization": {"Bearer staff"}}
+	call("GET", "/api/bookings", "/api/bookings?passenger=ada", "", staff, 200)
+	call("GET", "/api/bookings", "/api/bookings?limit=x", "", staff, 400)
+	call("GET", "/api/bookings", "/api/bookings", "", nil, 401)
+	call("GET", "/api/bookings/{reference}", "/api/bookings/"+reference, "", staff, 200)
+	call("GET", "/api/bookings/{reference}", "/api/bookings/UNKNOWN", "", staff, 404)
+	call("GET", "/api/bookings/{reference}", "/api/bookings/"+reference, "", nil, 401)
+	// Without the token nothing is cancelled
+	call("DELETE", "/api/bookings/{reference}", "/api/bookings/"+reference, "", http.Header{"Authorization": {"Bearer guess"}}, 401)
+	call("DELETE", "/api/bookings/{reference}", "/api/bookings/"+reference, "", staff, 200)
+	call("DELETE", "/api/bookings/{reference}", "/api/bookings/"+reference, "", staff, 409)
+	call("DELETE", "/api/bookings/{reference}", "/api/bookings/UNKNOWN", "", staff, 404)
+
+	call("GET", "/api/admin/status", "/api/admin/status", "", staff, 200)
+	call("GET", "/api/admin/status", "/api/admin/status", "", http.Header{"Authorization": {"Bearer guess"}}, 401)
+	call("GET", "/api/openapi.json", "/api/openapi.json", "", nil, 200)
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !called[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is documented but not tested", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// Checks a decoded JSON value against a schema of the document and lists every difference.
+// Objects may only have the documented properties, so fields added to a type without
+// updating the document show up as well.
+func checkSchema(doc *openapi.Document, schema *openapi.Schema, value any, at string) []string {
+	if schema == nil {
+		return []string{at + " has no schema"}
+	}
+	if schema.Ref != "" {
+		return checkSchema(doc, doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")], value, at)
+	}
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return []string{at + " is null"}
+	}
+
+	var problems []string
+	for _, part := range schema.AllOf {
+		problems = append(problems, checkSchema(doc, part, value, at)...)
+	}
+	fail := func(format string, args ...any) []string {
+		return append(problems, at+" "+fmt.Sprintf(format, args...))
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fail("is %T, not an object", value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				problems = fail("lacks %s", name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			switch {
+			case ok:
+				problems = append(problems, checkSchema(doc, property, object[name], at+"."+name)...)
+			case schema.AdditionalProperties != nil:
+				problems = append(problems, checkSchema(doc, schema.AdditionalProperties, object[name], at+"."+name)...)
+			case len(schema.Properties) > 0:
+				problems = fail("has undocumented property %s", name)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fail("is %T, not an array", value)
+		}
+		for i, item := range array {
+			problems = append(problems, checkSchema(doc, schema.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fail("is %T, not a string", value)
+		}
+		layouts := map[string]string{"date-time": time.RFC3339, "date": "2006-01-02"}
+		if layout, ok := layouts[schema.Format]; ok {
+			if _, err := time.Parse(layout, text); err != nil {
+				return fail("is %q, not a %s", text, schema.Format)
+			}
+		}
+		if len(schema.Enum) > 0 && !containsString(schema.Enum, text) {
+			return fail("is %q, not one of %v", text, schema.Enum)
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			return fail("is %T, not a %s", value, schema.Type)
+		}
+		if schema.Type == "integer" && number != math.Trunc(number) {
+			return fail("is %v, not an integer", number)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("is %T, not a boolean", value)
+		}
+	}
+	return problems
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Bookings and holds are decoded into structs.Booking, so every documented request property has to be
+// a field of it with the same type, or the document promises fields the handlers silently drop
+func TestBookingRequestMatchesDecodedType(t *testing.T) {
+	doc := apiDocument()
+	request := doc.Schema(bookingRequest{})
+	decoded := doc.Schema(structs.Booking{})
+	for _, problem := range compareFields(doc, request, decoded, "bookingRequest") {
+		t.Error(problem)
+	}
+}
+
+// Lists where the properties of a request schema are missing from or typed differently in the decoded one
+func compareFields(doc *openapi.Document, request *openapi.Schema, decoded *openapi.Schema, at string) []string {
+	resolve := func(schema *openapi.Schema) *openapi.Schema {
+		for {
+			switch {
+			case schema.Ref != "":
+				schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+			case len(schema.AllOf) == 1:
+				schema = schema.AllOf[0]
+			default:
+				return schema
+			}
+		}
+	}
+	request, decoded = resolve(request), resolve(decoded)
+	if request.Type != decoded.Type || request.Format != decoded.Format {
+		return []string{fmt.Sprintf("%s is %s %s but decoded as %s %s", at, request.Type, request.Format, decoded.Type, decoded.Format)}
+	}
+	var problems []string
+	switch request.Type {
+	case "array":
+		problems = append(problems, compareFields(doc, request.Items, decoded.Items, at+"[]")...)
+	case "object":
+		for name, property := range request.Properties {
+			field, ok := decoded.Properties[name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s is not decoded", at, name))
+				continue
+			}
+			problems = append(problems, compareFields(doc, property, field, at+"."+name)...)
+		}
+	}
+	return problems
+}
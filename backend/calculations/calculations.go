@@ -3,6 +3,8 @@ import (
 	"space-travel/structs"
 	"time"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 )
 // Struct to represent a route
@@ -11,24 +13,106 @@ type Route struct {
 	Destination string
 }
 
-var planetRoutes = map[string][]string{
-	"Mercury": {"Venus"},
-	"Venus":   {"Earth", "Mercury"},
-	"Earth":   {"Jupiter", "Uranus"},
-	"Mars":    {"Venus"},
-	"Jupiter": {"Mars", "Venus"},
-	"Saturn":  {"Earth", "Neptune"},
-	"Uranus":  {"Saturn", "Neptune"},
-	"Neptune": {"Uranus", "Mercury"},
+// Graph maps every planet to the planets reachable from it with a single leg
+type Graph map[string][]string
+
+// HasPlanet reports whether the planet appears anywhere in the graph
+func (g Graph) HasPlanet(planet string) bool {
+	_, ok := g[planet]
+	return ok
 }
-// Recursive function to calculate all possible routes between two planets
-func CalculateShortestRoute(from string, destination string) ([]Route) {
+
+// Recursive function to calculate all possible routes between two planets.
+// Routes are ordered by the number of jumps and capped at maxPaths when it is positive.
+func CalculateAllRoutes(graph Graph, from string, destination string, maxPaths int) [][]Route {
 	var allRoutes [][]string
-	calculateRoutes(from, destination, &allRoutes, []string{})
-	return shortestRoute(allRoutes)
+	calculateRoutes(graph, from, destination, &allRoutes, []string{})
+
+	sort.SliceStable(allRoutes, func(i, j int) bool {
+		return len(allRoutes[i]) < len(allRoutes[j])
+	})
+	if maxPaths > 0 && len(allRoutes) > maxPaths {
+		allRoutes = allRoutes[:maxPaths]
+	}
+
+	routes := make([][]Route, 0, len(allRoutes))
+	for _, planetNames := range allRoutes {
+		routes = append(routes, convertToRoute(planetNames))
+	}
+	return routes
+}
+
+// Stop is a planet an itinerary has to visit, staying at least MinStay before flying on
+type Stop struct {
+	Planet  string
+	MinStay time.Duration
+}
+
+// Journey is a path through the graph with the rule for each connection along it
+type Journey struct {
+	Route []Route
+	Rules []ConnectionRule
+}
+
+// PlanJourneys lists the paths from one planet to another through the required stops in order,
+// fewest jumps first and capped at maxPaths when it is positive. Connections at a stop
+// wait at least its MinStay and have no maximum layover, the others follow the connection rule.
+func PlanJourneys(graph Graph, from string, destination string, stops []Stop, rule ConnectionRule, maxPaths int) []Journey {
+	waypoints := []string{from}
+	for _, stop := range stops {
+		waypoints = append(waypoints, stop.Planet)
+	}
+	waypoints = append(waypoints, destination)
+
+	journeys := []Journey{{}}
+	for i := 0; i < len(waypoints)-1; i++ {
+		segments := CalculateAllRoutes(graph, waypoints[i], waypoints[i+1], maxPaths)
+		var combined []Journey
+		for _, journey := range journeys {
+			for _, segment := range segments {
+				if len(segment) == 0 {
+					continue
+				}
+				next := Journey{
+					Route: append(append([]Route{}, journey.Route...), segment...),
+					Rules: append([]ConnectionRule{}, journey.Rules...),
+				}
+				if i > 0 {
+					stay := stops[i-1].MinStay
+					if stay < rule.MinConnection {
+						stay = rule.MinConnection
+					}
+					next.Rules = append(next.Rules, ConnectionRule{MinConnection: stay})
+				}
+				next.Rules = append(next.Rules, uniformRules(rule, len(segment))...)
+				combined = append(combined, next)
+			}
+		}
+		journeys = combined
+	}
+
+	sort.SliceStable(journeys, func(i, j int) bool {
+		return len(journeys[i].Route) < len(journeys[j].Route)
+	})
+	if maxPaths > 0 && len(journeys) > maxPaths {
+		journeys = journeys[:maxPaths]
+	}
+	return journeys
+}
+
+// PathPlanets lists the planets visited by a route, including both endpoints
+func PathPlanets(route []Route) []string {
+	if len(route) == 0 {
+		return nil
+	}
+	planets := []string{route[0].From}
+	for _, jump := range route {
+		planets = append(planets, jump.Destination)
+	}
+	return planets
 }
 
-func calculateRoutes(currentPlanet string, destination string, allRoutes *[][]string, path []string) {
+func calculateRoutes(graph Graph, currentPlanet string, destination string, allRoutes *[][]string, path []string) {
 	pathCopy := make([]string, len(path))
 	copy(pathCopy, path)
 	
@@ -43,30 +127,14 @@ func calculateRoutes(currentPlanet string, destination string, allRoutes *[][]st
 		return
 	}
 
-	possibleDestinations, ok := planetRoutes[currentPlanet]
+	possibleDestinations, ok := graph[currentPlanet]
 	if !ok {
 		return
 	}
 
 	for _, nextPlanet := range possibleDestinations {
-		calculateRoutes(nextPlanet, destination, allRoutes, pathCopy)
-	}
-}
-
-func shortestRoute(allRoutes [][]string) []Route {
-	if len(allRoutes) == 0 {
-		return nil
-	}
-
-	shortest := allRoutes[0]
-
-	for _, route := range allRoutes {
-		if len(route) < len(shortest) {
-			shortest = route
-		}
+		calculateRoutes(graph, nextPlanet, destination, allRoutes, pathCopy)
 	}
-
-	return convertToRoute(shortest)
 }
 
 func convertToRoute(planetNames []string) []Route {
@@ -82,22 +150,34 @@ func convertToRoute(planetNames []string) []Route {
 	return route
 }
 
-func MakeCorrectRoutes(providers [][]structs.SimplifiedProvider) []structs.PossibleRoute {
-	var possiblePermutations = Loop(providers)
+// MakeCorrectRoutes searches the providers of each leg, with rules[i] between leg i and leg i+1
+func MakeCorrectRoutes(providers [][]structs.SimplifiedProvider, mode SearchMode, rules []ConnectionRule) []structs.PossibleRoute {
 	nrOfJumps := len(providers)
+	var itineraries [][]int
+	if mode == ParetoMode {
+		itineraries = ParetoItineraries(providers, rules)
+	} else {
+		itineraries = EarliestArrivals(providers, rules)
+	}
 	routes := []structs.PossibleRoute{}
 
-	for _, permutation := range possiblePermutations {
+	for _, itinerary := range itineraries {
 		route := structs.PossibleRoute{}
 		var totalPrice float64
 		var totalDuration time.Duration
+		var totalLayover time.Duration
 		var firstTakeoff time.Time
 		var lastLanding time.Time
 
 		for i := 0; i < nrOfJumps; i++ {
-			provider := providers[i][permutation[i]]
+			provider := providers[i][itinerary[i]]
 			route.Providers = append(route.Providers, provider)
 			totalPrice += provider.Price
+			if i > 0 {
+				layover := provider.FlightStart.Sub(providers[i-1][itinerary[i-1]].FlightEnd)
+				route.LayoverSeconds = append(route.LayoverSeconds, int64(layover/time.Second))
+				totalLayover += layover
+			}
 
 			if i == 0 || provider.FlightStart.Before(firstTakeoff) {
 				firstTakeoff = provider.FlightStart
@@ -112,61 +192,40 @@ func MakeCorrectRoutes(providers [][]structs.SimplifiedProvider) []structs.Possi
 		// Calculate total duration
 		totalDuration = lastLanding.Sub(firstTakeoff).Round(time.Minute)
 
-		// Format total duration as days, hours, and minutes
-		days := totalDuration / (24 * time.Hour)
-		totalDuration = totalDuration % (24 * time.Hour)
-		hours := totalDuration / time.Hour
-		totalDuration = totalDuration % time.Hour
-		minutes := totalDuration / time.Minute
-
-		durationString := ""
-		if days > 0 {
-			durationString += fmt.Sprintf("%d days, ", days)
-		}
-		if hours > 0 {
-			durationString += fmt.Sprintf("%d hours, ", hours)
-		}
-		durationString += fmt.Sprintf("%d minutes", minutes)
+		// Machine-readable totals next to the display strings
+		route.TotalPriceMinor = int64(math.Round(totalPrice * 100))
+		route.TotalDurationSeconds = int64(lastLanding.Sub(firstTakeoff) / time.Second)
+		route.TotalLayoverSeconds = int64(totalLayover / time.Second)
+		route.FirstDeparture = firstTakeoff
+		route.LastArrival = lastLanding
+		route.NumberOfLegs = nrOfJumps
 
 		route.TotalPrice = fmt.Sprintf("%.2f", totalPrice)
-		route.TotalDuration = durationString
+		route.TotalDuration = FormatDuration(totalDuration)
 		routes = append(routes, route)
 	}
 
 	return routes
 }
 
-func Loop(providers [][]structs.SimplifiedProvider) [][]int {
-	var nrOfJumps = len(providers)
-	var lengthsOfProviders = make([]int, nrOfJumps)
-	for i := 0; i < nrOfJumps; i++ {
-		lengthsOfProviders[i] = len(providers[i])
+// FormatDuration formats a duration rounded to minutes as days, hours, and minutes
+func FormatDuration(totalDuration time.Duration) string {
+	totalDuration = totalDuration.Round(time.Minute)
+	days := totalDuration / (24 * time.Hour)
+	totalDuration = totalDuration % (24 * time.Hour)
+	hours := totalDuration / time.Hour
+	totalDuration = totalDuration % time.Hour
+	minutes := totalDuration / time.Minute
+
+	durationString := ""
+	if days > 0 {
+		durationString += fmt.Sprintf("%d days, ", days)
 	}
-
-	var permutationsArray = make([][]int, 0)
-	var counterArray = make([]int, nrOfJumps)
-
-	var generatePermutations func(int)
-	generatePermutations = func(pos int) {
-		if pos == nrOfJumps {
-			// Create a copy of the counterArray and append it to permutationsArray
-			tmp := make([]int, nrOfJumps)
-			copy(tmp, counterArray)
-			permutationsArray = append(permutationsArray, tmp)
-			return
-		}
-
-		for i := 0; i < lengthsOfProviders[pos]; i++ {
-			// Check if the flights match the time condition
-			if pos == 0 || timesMatch(providers[pos-1][counterArray[pos-1]], providers[pos][i]) {
-				counterArray[pos] = i
-				generatePermutations(pos + 1)
-			}
-		}
+	if hours > 0 {
+		durationString += fmt.Sprintf("%d hours, ", hours)
 	}
-
-	generatePermutations(0)
-	return permutationsArray
+	durationString += fmt.Sprintf("%d minutes", minutes)
+	return durationString
 }
 
 func timesMatch(providerA structs.SimplifiedProvider, providerB structs.SimplifiedProvider) bool{
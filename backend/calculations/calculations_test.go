@@ -0,0 +1,198 @@
+package calculations
+
+import (
+	"fmt"
+	"space-travel/structs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Earth reaches Jupiter directly, through Mars or Venus, and through both, with Mars and Venus
+// linked both ways and Jupiter leading back to Earth
+var fixtureGraph = Graph{
+	"Earth":   {"Jupiter", "Mars", "Venus"},
+	"Mars":    {"Jupiter", "Venus"},
+	"Venus":   {"Jupiter", "Mars"},
+	"Jupiter": {"Earth"},
+	"Saturn":  nil,
+}
+
+func routeString(route []Route) string {
+	return strings.Join(PathPlanets(route), ">")
+}
+
+func TestCalculateAllRoutes(t *testing.T) {
+	tests := []struct {
+		from, destination string
+		maxPaths          int
+		want              []string
+	}{
+		{"Earth", "Jupiter", 0, []string{
+			"Earth>Jupiter",
+			"Earth>Mars>Jupiter",
+			"Earth>Venus>Jupiter",
+			"Earth>Mars>Venus>Jupiter",
+			"Earth>Venus>Mars>Jupiter",
+		}},
+		// The cap keeps the paths with the fewest jumps
+		{"Earth", "Jupiter", 3, []string{"Earth>Jupiter", "Earth>Mars>Jupiter", "Earth>Venus>Jupiter"}},
+		{"Earth", "Jupiter", 10, []string{
+			"Earth>Jupiter",
+			"Earth>Mars>Jupiter",
+			"Earth>Venus>Jupiter",
+			"Earth>Mars>Venus>Jupiter",
+			"Earth>Venus>Mars>Jupiter",
+		}},
+		// Paths never go through a planet twice, even where the graph has a cycle
+		{"Mars", "Earth", 0, []string{"Mars>Jupiter>Earth", "Mars>Venus>Jupiter>Earth"}},
+		{"Earth", "Saturn", 0, nil},
+		{"Saturn", "Earth", 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s to %s max %d", test.from, test.destination, test.maxPaths), func(t *testing.T) {
+			var got []string
+			for _, route := range CalculateAllRoutes(fixtureGraph, test.from, test.destination, test.maxPaths) {
+				for i := 1; i < len(route); i++ {
+					if route[i].From != route[i-1].Destination {
+						t.Fatalf("route %v is not connected", route)
+					}
+				}
+				got = append(got, routeString(route))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Fatalf("routes are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMakeCorrectRoutesTotals(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	flight := func(id string, price float64, departure time.Duration, arrival time.Duration) []structs.SimplifiedProvider {
+		return []structs.SimplifiedProvider{{ID: id, Price: price, FlightStart: start.Add(departure), FlightEnd: start.Add(arrival)}}
+	}
+	// Prices that do not add up exactly in floating point
+	providers := [][]structs.SimplifiedProvider{
+		flight("first", 19.99, 0, time.Hour+30*time.Minute+30*time.Second),
+		flight("second", 0.01, 3*time.Hour, 5*time.Hour),
+		flight("third", 0.3, 6*time.Hour+15*time.Minute, 7*time.Hour),
+	}
+	for _, mode := range []SearchMode{EarliestArrivalMode, ParetoMode} {
+		routes := MakeCorrectRoutes(providers, mode, uniformRules(ConnectionRule{}, len(providers)))
+		if len(routes) != 1 {
+			t.Fatalf("mode %v found %d routes, want 1", mode, len(routes))
+		}
+		route := routes[0]
+		if route.TotalPriceMinor != 2030 || route.TotalPrice != "20.30" {
+			t.Fatalf("mode %v totals %d minor units, %q, want 2030 and 20.30", mode, route.TotalPriceMinor, route.TotalPrice)
+		}
+		if route.TotalDurationSeconds != 7*3600 || route.TotalDuration != "7 hours, 0 minutes" {
+			t.Fatalf("mode %v takes %d seconds, %q, want 25200 seconds", mode, route.TotalDurationSeconds, route.TotalDuration)
+		}
+		if fmt.Sprint(route.LayoverSeconds) != "[5370 4500]" || route.TotalLayoverSeconds != 9870 {
+			t.Fatalf("mode %v has layovers %v totalling %d seconds, want [5370 4500] totalling 9870", mode, route.LayoverSeconds, route.TotalLayoverSeconds)
+		}
+		if !route.FirstDeparture.Equal(start) || !route.LastArrival.Equal(start.Add(7*time.Hour)) || route.NumberOfLegs != 3 {
+			t.Fatalf("mode %v flies %d legs from %s to %s", mode, route.NumberOfLegs, route.FirstDeparture, route.LastArrival)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                             "0 minutes",
+		29 * time.Second:              "0 minutes",
+		90 * time.Second:              "2 minutes",
+		time.Hour:                     "1 hours, 0 minutes",
+		49*time.Hour + 5*time.Minute:  "2 days, 1 hours, 5 minutes",
+		48*time.Hour + 59*time.Second: "2 days, 1 minutes",
+		24*time.Hour - 20*time.Second: "1 days, 0 minutes",
+	}
+	for duration, want := range tests {
+		if got := FormatDuration(duration); got != want {
+			t.Fatalf("%s formats as %q, want %q", duration, got, want)
+		}
+	}
+}
+
+func TestPlanJourneys(t *testing.T) {
+	// Mars and Jupiter in a row, with a shortcut from Earth to Jupiter
+	graph := Graph{
+		"Earth":   {"Jupiter", "Mars"},
+		"Mars":    {"Jupiter"},
+		"Jupiter": {"Saturn"},
+		"Saturn":  nil,
+	}
+	rule := ConnectionRule{MinConnection: time.Hour, MaxLayover: 6 * time.Hour}
+	atStop := func(stay time.Duration) string {
+		return fmt.Sprint(ConnectionRule{MinConnection: stay})
+	}
+	tests := []struct {
+		name  string
+		stops []Stop
+		want  []string
+	}{
+		{"no stops", nil, []string{
+			"Earth>Jupiter>Saturn " + fmt.Sprint([]ConnectionRule{rule}),
+			"Earth>Mars>Jupiter>Saturn " + fmt.Sprint([]ConnectionRule{rule, rule}),
+		}},
+		{"one stop", []Stop{{Planet: "Jupiter", MinStay: 48 * time.Hour}}, []string{
+			"Earth>Jupiter>Saturn [" + atStop(48*time.Hour) + "]",
+			"Earth>Mars>Jupiter>Saturn [" + fmt.Sprint(rule) + " " + atStop(48*time.Hour) + "]",
+		}},
+		// A stay shorter than the minimum connection still waits the minimum connection
+		{"stays at each stop in order", []Stop{{Planet: "Mars", MinStay: 30 * time.Minute}, {Planet: "Jupiter", MinStay: 24 * time.Hour}}, []string{
+			"Earth>Mars>Jupiter>Saturn [" + atStop(time.Hour) + " " + atStop(24*time.Hour) + "]",
+		}},
+		{"stops in the wrong order", []Stop{{Planet: "Jupiter"}, {Planet: "Mars"}}, nil},
+		{"unreachable stop", []Stop{{Planet: "Pluto"}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, journey := range PlanJourneys(graph, "Earth", "Saturn", test.stops, rule, 0) {
+				if len(journey.Rules) != len(journey.Route)-1 {
+					t.Fatalf("%s has %d rules", routeString(journey.Route), len(journey.Rules))
+				}
+				got = append(got, routeString(journey.Route)+" "+fmt.Sprint(journey.Rules))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Fatalf("journeys are\n%v\nwant\n%v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanJourneysMinStay(t *testing.T) {
+	graph := Graph{"Earth": {"Mars"}, "Mars": {"Jupiter"}, "Jupiter": nil}
+	rule := ConnectionRule{MinConnection: time.Hour, MaxLayover: 6 * time.Hour}
+	journeys := PlanJourneys(graph, "Earth", "Jupiter", []Stop{{Planet: "Mars", MinStay: 48 * time.Hour}}, rule, 0)
+	if len(journeys) != 1 {
+		t.Fatalf("planned %d journeys, want 1", len(journeys))
+	}
+
+	landing := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	flight := func(id string, departure time.Time) structs.SimplifiedProvider {
+		return structs.SimplifiedProvider{ID: id, Price: 100, FlightStart: departure, FlightEnd: departure.Add(time.Hour)}
+	}
+	tests := []struct {
+		onward []structs.SimplifiedProvider
+		want   string
+	}{
+		// The maximum layover does not apply while staying
+		{[]structs.SimplifiedProvider{flight("short", landing.Add(47*time.Hour)), flight("exact", landing.Add(48*time.Hour))}, "[exact]"},
+		{[]structs.SimplifiedProvider{flight("short", landing.Add(48*time.Hour-time.Second))}, "[]"},
+		{[]structs.SimplifiedProvider{flight("long", landing.Add(30*24*time.Hour))}, "[long]"},
+	}
+	for _, test := range tests {
+		providers := [][]structs.SimplifiedProvider{{flight("there", landing.Add(-time.Hour))}, test.onward}
+		ids := []string{}
+		for _, route := range MakeCorrectRoutes(providers, EarliestArrivalMode, journeys[0].Rules) {
+			ids = append(ids, route.Providers[1].ID)
+		}
+		if fmt.Sprint(ids) != test.want {
+			t.Fatalf("onward flights %v were taken as %v, want %s", test.onward, ids, test.want)
+		}
+	}
+}
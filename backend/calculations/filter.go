@@ -0,0 +1,131 @@
+package calculations
+
+import (
+	"sort"
+	"space-travel/structs"
+	"time"
+)
+
+// Keys the possible routes can be sorted by
+const (
+	SortByPrice     = "price"
+	SortByDuration  = "duration"
+	SortByDeparture = "departure"
+	SortByArrival   = "arrival"
+)
+
+// RouteQuery filters, sorts and pages possible routes. Zero values disable a filter.
+type RouteQuery struct {
+	SortBy     string
+	Descending bool
+	// Routes must use at least one of these companies, matched by name or ID
+	Companies []string
+	// Routes must not use any of these companies, matched by name or ID
+	ExcludedCompanies []string
+	MaxPrice          float64
+	MaxDuration       time.Duration
+	DepartAfter       time.Time
+	DepartBefore      time.Time
+	Limit             int
+	Offset            int
+}
+
+// IsValidSortKey reports whether routes can be sorted by the given key
+func IsValidSortKey(key string) bool {
+	switch key {
+	case SortByPrice, SortByDuration, SortByDeparture, SortByArrival:
+		return true
+	}
+	return false
+}
+
+// ApplyRouteQuery returns the requested page of matching routes and the number of routes that matched
+func ApplyRouteQuery(routes []structs.PossibleRoute, query RouteQuery) ([]structs.PossibleRoute, int) {
+	matching := []structs.PossibleRoute{}
+	for _, route := range routes {
+		if routeMatches(route, query) {
+			matching = append(matching, route)
+		}
+	}
+
+	if query.SortBy != "" {
+		sort.SliceStable(matching, func(i, j int) bool {
+			if query.Descending {
+				return routeLess(matching[j], matching[i], query.SortBy)
+			}
+			return routeLess(matching[i], matching[j], query.SortBy)
+		})
+	}
+
+	total := len(matching)
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	if query.Offset >= total {
+		return []structs.PossibleRoute{}, total
+	}
+	matching = matching[query.Offset:]
+	if query.Limit > 0 && len(matching) > query.Limit {
+		matching = matching[:query.Limit]
+	}
+	return matching, total
+}
+
+func routeMatches(route structs.PossibleRoute, query RouteQuery) bool {
+	if len(route.Providers) == 0 {
+		return false
+	}
+	if len(query.Companies) > 0 && !routeUsesAnyCompany(route, query.Companies) {
+		return false
+	}
+	if len(query.ExcludedCompanies) > 0 && routeUsesAnyCompany(route, query.ExcludedCompanies) {
+		return false
+	}
+	if query.MaxPrice > 0 && routePrice(route) > query.MaxPrice {
+		return false
+	}
+	if query.MaxDuration > 0 && routeDuration(route) > query.MaxDuration {
+		return false
+	}
+	departure := routeDeparture(route)
+	if !query.DepartAfter.IsZero() && departure.Before(query.DepartAfter) {
+		return false
+	}
+	if !query.DepartBefore.IsZero() && departure.After(query.DepartBefore) {
+		return false
+	}
+	return true
+}
+
+func routeUsesAnyCompany(route structs.PossibleRoute, companies []string) bool {
+	for _, provider := range route.Providers {
+		for _, company := range companies {
+			if provider.CompanyName == company || provider.CompanyID == company {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func routeLess(a structs.PossibleRoute, b structs.PossibleRoute, key string) bool {
+	switch key {
+	case SortByPrice:
+		return routePrice(a) < routePrice(b)
+	case SortByDuration:
+		return routeDuration(a) < routeDuration(b)
+	case SortByDeparture:
+		return routeDeparture(a).Before(routeDeparture(b))
+	case SortByArrival:
+		return routeArrival(a).Before(routeArrival(b))
+	}
+	return false
+}
+
+func routeDeparture(route structs.PossibleRoute) time.Time {
+	return route.Providers[0].FlightStart
+}
+
+func routeArrival(route structs.PossibleRoute) time.Time {
+	return route.Providers[len(route.Providers)-1].FlightEnd
+}
@@ -0,0 +1,105 @@
+package calculations
+
+import (
+	"space-travel/structs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Four routes, named by the ID of their first provider:
+//
+//	A  Alpha        300  10:00-12:00
+//	B  Beta         100  08:00-13:00
+//	C  Alpha+Gamma  250  09:00-11:30
+//	D  Gamma        200  12:00-13:30
+func filterFixture() []structs.PossibleRoute {
+	day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := func(id string, company string, price float64, start time.Duration, end time.Duration) structs.SimplifiedProvider {
+		return structs.SimplifiedProvider{
+			ID:          id,
+			CompanyID:   strings.ToLower(company) + "-id",
+			CompanyName: company,
+			Price:       price,
+			FlightStart: day.Add(start),
+			FlightEnd:   day.Add(end),
+		}
+	}
+	return []structs.PossibleRoute{
+		{Providers: []structs.SimplifiedProvider{provider("A", "Alpha", 300, 10*time.Hour, 12*time.Hour)}},
+		{Providers: []structs.SimplifiedProvider{provider("B", "Beta", 100, 8*time.Hour, 13*time.Hour)}},
+		{Providers: []structs.SimplifiedProvider{
+			provider("C", "Alpha", 150, 9*time.Hour, 10*time.Hour),
+			provider("C2", "Gamma", 100, 11*time.Hour, 11*time.Hour+30*time.Minute),
+		}},
+		{Providers: []structs.SimplifiedProvider{provider("D", "Gamma", 200, 12*time.Hour, 13*time.Hour+30*time.Minute)}},
+		// Routes without providers never match
+		{},
+	}
+}
+
+func TestApplyRouteQuery(t *testing.T) {
+	day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query RouteQuery
+		want  string
+		total int
+	}{
+		{"no query", RouteQuery{}, "ABCD", 4},
+		{"price", RouteQuery{SortBy: SortByPrice}, "BDCA", 4},
+		{"price descending", RouteQuery{SortBy: SortByPrice, Descending: true}, "ACDB", 4},
+		{"duration", RouteQuery{SortBy: SortByDuration}, "DACB", 4},
+		{"departure", RouteQuery{SortBy: SortByDeparture}, "BCAD", 4},
+		{"arrival", RouteQuery{SortBy: SortByArrival}, "CABD", 4},
+		{"arrival descending", RouteQuery{SortBy: SortByArrival, Descending: true}, "DBAC", 4},
+		{"company by name", RouteQuery{Companies: []string{"Gamma"}}, "CD", 2},
+		{"company by ID", RouteQuery{Companies: []string{"gamma-id"}}, "CD", 2},
+		{"any of the companies", RouteQuery{Companies: []string{"Beta", "Gamma"}}, "BCD", 3},
+		{"unknown company", RouteQuery{Companies: []string{"Delta"}}, "", 0},
+		{"excluded company on any leg", RouteQuery{ExcludedCompanies: []string{"Alpha"}}, "BD", 2},
+		{"included and excluded company", RouteQuery{Companies: []string{"Alpha"}, ExcludedCompanies: []string{"gamma-id"}}, "A", 1},
+		{"max price is inclusive", RouteQuery{MaxPrice: 250}, "BCD", 3},
+		{"max duration is inclusive", RouteQuery{MaxDuration: 2 * time.Hour}, "AD", 2},
+		{"departure window is inclusive", RouteQuery{DepartAfter: day.Add(9 * time.Hour), DepartBefore: day.Add(10 * time.Hour)}, "AC", 2},
+		{"departure window is empty", RouteQuery{DepartAfter: day.Add(13 * time.Hour)}, "", 0},
+		{"filters are sorted", RouteQuery{MaxPrice: 250, SortBy: SortByPrice, Descending: true}, "CDB", 3},
+		{"limit", RouteQuery{Limit: 2}, "AB", 4},
+		{"limit past the end", RouteQuery{Limit: 10}, "ABCD", 4},
+		{"offset", RouteQuery{Offset: 3}, "D", 4},
+		{"offset at the end", RouteQuery{Offset: 4}, "", 4},
+		{"offset past the end", RouteQuery{Offset: 10}, "", 4},
+		{"limit and offset", RouteQuery{Limit: 2, Offset: 1, SortBy: SortByPrice}, "DC", 4},
+		{"page of the filtered routes", RouteQuery{Companies: []string{"Gamma"}, Limit: 1, Offset: 1}, "D", 2},
+		{"negative limit", RouteQuery{Limit: -1}, "ABCD", 4},
+		{"negative offset", RouteQuery{Offset: -1}, "ABCD", 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, total := ApplyRouteQuery(filterFixture(), test.query)
+			var got string
+			for _, route := range routes {
+				got += route.Providers[0].ID
+			}
+			if got != test.want || total != test.total {
+				t.Fatalf("got %q of %d routes, want %q of %d", got, total, test.want, test.total)
+			}
+			if routes == nil {
+				t.Fatal("no matching routes is nil instead of an empty list")
+			}
+		})
+	}
+}
+
+func TestIsValidSortKey(t *testing.T) {
+	for _, key := range []string{SortByPrice, SortByDuration, SortByDeparture, SortByArrival} {
+		if !IsValidSortKey(key) {
+			t.Fatalf("%s is not a valid sort key", key)
+		}
+	}
+	for _, key := range []string{"", "Price", "layover"} {
+		if IsValidSortKey(key) {
+			t.Fatalf("%q is a valid sort key", key)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package calculations
+
+import (
+	"math"
+	"sort"
+	"space-travel/structs"
+	"time"
+)
+
+// Partial itinerary kept while searching leg by leg from one first departure
+type paretoLabel struct {
+	itinerary []int
+	end       time.Time
+	price     float64
+	companies map[string]bool
+}
+
+func (a paretoLabel) usesSubsetOf(b paretoLabel) bool {
+	for company := range a.companies {
+		if !b.companies[company] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a paretoLabel) extend(leg int, index int, provider structs.SimplifiedProvider) paretoLabel {
+	itinerary := make([]int, leg+1)
+	copy(itinerary, a.itinerary)
+	itinerary[leg] = index
+
+	companies := make(map[string]bool, len(a.companies)+1)
+	for company := range a.companies {
+		companies[company] = true
+	}
+	companies[provider.CompanyID] = true
+
+	return paretoLabel{
+		itinerary: itinerary,
+		end:       provider.FlightEnd,
+		price:     a.price + provider.Price,
+		companies: companies,
+	}
+}
+
+// ParetoItineraries searches the legs with a multi-criteria label search and returns
+// the itineraries that are not beaten on price, arrival and companies by another one
+// with the same first departure, respecting rules[i] between leg i and leg i+1.
+// Comparing different departures is left to ParetoFront.
+// The result holds one provider index per leg.
+func ParetoItineraries(providers [][]structs.SimplifiedProvider, rules []ConnectionRule) [][]int {
+	nrOfJumps := len(providers)
+	if nrOfJumps == 0 {
+		return nil
+	}
+
+	var itineraries [][]int
+	for i, first := range providers[0] {
+		labels := []paretoLabel{{
+			itinerary: []int{i},
+			end:       first.FlightEnd,
+			price:     first.Price,
+			companies: map[string]bool{first.CompanyID: true},
+		}}
+
+		for leg := 1; leg < nrOfJumps && len(labels) > 0; leg++ {
+			var extended []paretoLabel
+			for _, label := range labels {
+				previous := providers[leg-1][label.itinerary[leg-1]]
+				for j, provider := range providers[leg] {
+					if rules[leg-1].Allows(previous, provider) {
+						extended = append(extended, label.extend(leg, j, provider))
+					}
+				}
+			}
+			labels = pruneLabels(extended, leg < nrOfJumps-1 && rules[leg].MaxLayover > 0)
+		}
+
+		for _, label := range labels {
+			itineraries = append(itineraries, label.itinerary)
+		}
+	}
+	return itineraries
+}
+
+// Drops labels another label beats by landing no later, costing no more and using a subset
+// of its companies. When the next connection has a maximum layover, landing sooner can miss
+// a flight, so only labels landing at the same time are compared.
+func pruneLabels(labels []paretoLabel, exactEnd bool) []paretoLabel {
+	sort.SliceStable(labels, func(i, j int) bool {
+		if !labels[i].end.Equal(labels[j].end) {
+			return labels[i].end.Before(labels[j].end)
+		}
+		if labels[i].price != labels[j].price {
+			return labels[i].price < labels[j].price
+		}
+		return len(labels[i].companies) < len(labels[j].companies)
+	})
+
+	// Every kept label lands no later than the current one, so only price and companies need checking
+	var kept []paretoLabel
+	comparable := 0
+	for i, label := range labels {
+		if exactEnd && i > 0 && !label.end.Equal(labels[i-1].end) {
+			comparable = len(kept)
+		}
+		dominated := false
+		for _, other := range kept[comparable:] {
+			if other.price <= label.price && other.usesSubsetOf(label) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			kept = append(kept, label)
+		}
+	}
+	return kept
+}
+
+// ParetoFront drops every route that another route matches or beats on price, duration
+// and number of companies while being strictly better on at least one of them.
+// Of routes that tie on all three only the first is kept.
+func ParetoFront(routes []structs.PossibleRoute) []structs.PossibleRoute {
+	type point struct {
+		index     int
+		price     int64
+		duration  time.Duration
+		companies int
+	}
+	points := make([]point, len(routes))
+	for i, route := range routes {
+		points[i] = point{i, int64(math.Round(routePrice(route) * 100)), routeDuration(route), routeCompanyCount(route)}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].price != points[j].price {
+			return points[i].price < points[j].price
+		}
+		if points[i].duration != points[j].duration {
+			return points[i].duration < points[j].duration
+		}
+		return points[i].companies < points[j].companies
+	})
+
+	// Every point seen so far is no more expensive, so a point is beaten when one
+	// with at most as many companies was also no slower
+	fastest := map[int]time.Duration{}
+	var kept []int
+	for _, p := range points {
+		dominated := false
+		for companies, duration := range fastest {
+			if companies <= p.companies && duration <= p.duration {
+				dominated = true
+				break
+			}
+		}
+		if dominated {
+			continue
+		}
+		kept = append(kept, p.index)
+		if duration, ok := fastest[p.companies]; !ok || p.duration < duration {
+			fastest[p.companies] = p.duration
+		}
+	}
+
+	sort.Ints(kept)
+	front := make([]structs.PossibleRoute, 0, len(kept))
+	for _, index := range kept {
+		front = append(front, routes[index])
+	}
+	return front
+}
+
+func routePrice(route structs.PossibleRoute) float64 {
+	var price float64
+	for _, provider := range route.Providers {
+		price += provider.Price
+	}
+	return price
+}
+
+func routeDuration(route structs.PossibleRoute) time.Duration {
+	if len(route.Providers) == 0 {
+		return 0
+	}
+	return route.Providers[len(route.Providers)-1].FlightEnd.Sub(route.Providers[0].FlightStart)
+}
+
+func routeCompanyCount(route structs.PossibleRoute) int {
+	companies := map[string]bool{}
+	for _, provider := range route.Providers {
+		companies[provider.CompanyID] = true
+	}
+	return len(companies)
+}
@@ -0,0 +1,219 @@
+package calculations
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// The Pareto search as it was before labels were kept per first departure and pruned with a
+// sorted sweep, kept as a reference for the test and the benchmark
+
+// Partial itinerary kept while searching leg by leg
+type referenceLabel struct {
+	itinerary []int
+	start     time.Time
+	end       time.Time
+	price     float64
+	companies map[string]bool
+}
+
+// A label is only worth extending if no other label departs later, lands sooner,
+// costs less and uses a subset of its companies. When the next connection has a
+// maximum layover, landing sooner can miss a flight, so the landings must match.
+func (a referenceLabel) dominates(b referenceLabel, exactEnd bool) bool {
+	if a.start.Before(b.start) || a.end.After(b.end) || a.price > b.price {
+		return false
+	}
+	if exactEnd && !a.end.Equal(b.end) {
+		return false
+	}
+	for company := range a.companies {
+		if !b.companies[company] {
+			return false
+		}
+	}
+	return true
+}
+
+func (a referenceLabel) extend(leg int, index int, provider structs.SimplifiedProvider) referenceLabel {
+	itinerary := make([]int, leg+1)
+	copy(itinerary, a.itinerary)
+	itinerary[leg] = index
+
+	companies := make(map[string]bool, len(a.companies)+1)
+	for company := range a.companies {
+		companies[company] = true
+	}
+	companies[provider.CompanyID] = true
+
+	return referenceLabel{
+		itinerary: itinerary,
+		start:     a.start,
+		end:       provider.FlightEnd,
+		price:     a.price + provider.Price,
+		companies: companies,
+	}
+}
+
+// referenceItineraries searches the legs with a multi-criteria label search and returns
+// every itinerary that is not beaten on price, duration and number of companies at once,
+// respecting rules[i] between leg i and leg i+1.
+// The result holds one provider index per leg.
+func referenceItineraries(providers [][]structs.SimplifiedProvider, rules []ConnectionRule) [][]int {
+	nrOfJumps := len(providers)
+	if nrOfJumps == 0 {
+		return nil
+	}
+
+	var labels []referenceLabel
+	for i, provider := range providers[0] {
+		labels = append(labels, referenceLabel{
+			itinerary: []int{i},
+			start:     provider.FlightStart,
+			end:       provider.FlightEnd,
+			price:     provider.Price,
+			companies: map[string]bool{provider.CompanyID: true},
+		})
+	}
+	labels = referencePrune(labels, nrOfJumps > 1 && rules[0].MaxLayover > 0)
+
+	for leg := 1; leg < nrOfJumps; leg++ {
+		var extended []referenceLabel
+		for _, label := range labels {
+			previous := providers[leg-1][label.itinerary[leg-1]]
+			for i, provider := range providers[leg] {
+				if rules[leg-1].Allows(previous, provider) {
+					extended = append(extended, label.extend(leg, i, provider))
+				}
+			}
+		}
+		labels = referencePrune(extended, leg < nrOfJumps-1 && rules[leg].MaxLayover > 0)
+	}
+
+	itineraries := make([][]int, 0, len(labels))
+	for _, label := range labels {
+		itineraries = append(itineraries, label.itinerary)
+	}
+	return itineraries
+}
+
+func referencePrune(labels []referenceLabel, exactEnd bool) []referenceLabel {
+	var kept []referenceLabel
+	for i, label := range labels {
+		dominated := false
+		for j, other := range labels {
+			if i == j || !other.dominates(label, exactEnd) {
+				continue
+			}
+			// Of two identical labels only the first one survives
+			if label.dominates(other, exactEnd) && i < j {
+				continue
+			}
+			dominated = true
+			break
+		}
+		if !dominated {
+			kept = append(kept, label)
+		}
+	}
+	return kept
+}
+
+// referenceFront drops every route that another route matches or beats on price, duration
+// and number of companies while being strictly better on at least one of them
+func referenceFront(routes []structs.PossibleRoute) []structs.PossibleRoute {
+	front := []structs.PossibleRoute{}
+	for i, route := range routes {
+		dominated := false
+		for j, other := range routes {
+			if i == j {
+				continue
+			}
+			if referenceDominates(other, route) || (j < i && referenceEquivalent(other, route)) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, route)
+		}
+	}
+	return front
+}
+
+func referenceDominates(a structs.PossibleRoute, b structs.PossibleRoute) bool {
+	priceA, priceB := routePrice(a), routePrice(b)
+	durationA, durationB := routeDuration(a), routeDuration(b)
+	companiesA, companiesB := routeCompanyCount(a), routeCompanyCount(b)
+
+	if priceA > priceB || durationA > durationB || companiesA > companiesB {
+		return false
+	}
+	return priceA < priceB || durationA < durationB || companiesA < companiesB
+}
+
+func referenceEquivalent(a structs.PossibleRoute, b structs.PossibleRoute) bool {
+	return routePrice(a) == routePrice(b) &&
+		routeDuration(a) == routeDuration(b) &&
+		routeCompanyCount(a) == routeCompanyCount(b)
+}
+
+func paretoRoutes(providers [][]structs.SimplifiedProvider, itineraries [][]int) []structs.PossibleRoute {
+	routes := make([]structs.PossibleRoute, len(itineraries))
+	for i, itinerary := range itineraries {
+		for leg, index := range itinerary {
+			routes[i].Providers = append(routes[i].Providers, providers[leg][index])
+		}
+	}
+	return routes
+}
+
+// Price, duration and companies of every route on the front, which is what both searches have to agree on
+func frontCriteria(routes []structs.PossibleRoute) []string {
+	criteria := make([]string, len(routes))
+	for i, route := range routes {
+		criteria[i] = fmt.Sprint(int64(math.Round(routePrice(route)*100)), routeDuration(route), routeCompanyCount(route))
+	}
+	sort.Strings(criteria)
+	return criteria
+}
+
+func TestParetoFrontMatchesReference(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		legs := 1 + int(seed%3)
+		providers := generateLegs(legs, 1+int(seed%15), seed)
+		rules := uniformRules(ConnectionRule{MaxLayover: time.Duration(seed%2) * 72 * time.Hour}, legs)
+
+		expected := frontCriteria(referenceFront(paretoRoutes(providers, referenceItineraries(providers, rules))))
+		got := frontCriteria(ParetoFront(paretoRoutes(providers, ParetoItineraries(providers, rules))))
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatalf("seed %d: got %v, want %v", seed, got, expected)
+		}
+	}
+}
+
+func BenchmarkParetoFront(b *testing.B) {
+	for _, perLeg := range []int{50, 150, 300} {
+		providers := generateLegs(3, perLeg, 1)
+		rules := uniformRules(ConnectionRule{}, len(providers))
+
+		b.Run(fmt.Sprintf("search/providers=%d", perLeg), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ParetoFront(paretoRoutes(providers, ParetoItineraries(providers, rules)))
+			}
+		})
+		// The reference takes over a minute at 300 providers per leg
+		if perLeg > 150 {
+			continue
+		}
+		b.Run(fmt.Sprintf("reference/providers=%d", perLeg), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				referenceFront(paretoRoutes(providers, referenceItineraries(providers, rules)))
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package calculations
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"space-travel/structs"
+	"time"
+)
+
+// PairRoundTrips combines outbound routes with return routes that depart at least minStay
+// after the outbound landing, cheapest pairs first. Only the first keep pairs are built,
+// so the cross product of both directions is never held in memory, and the number of
+// pairs there are in total is returned next to them.
+func PairRoundTrips(outbound []structs.PossibleRoute, inbound []structs.PossibleRoute, minStay time.Duration, keep int) ([]structs.RoundTrip, int) {
+	kept := &pairHeap{outbound: outbound, inbound: inbound}
+	total := 0
+	for i, there := range outbound {
+		if len(there.Providers) == 0 {
+			continue
+		}
+		for j, back := range inbound {
+			if len(back.Providers) == 0 {
+				continue
+			}
+			stay := routeDeparture(back).Sub(routeArrival(there))
+			if stay <= 0 || stay < minStay {
+				continue
+			}
+
+			total++
+			pair := routePair{there: i, back: j, priceMinor: int64(math.Round((routePrice(there) + routePrice(back)) * 100))}
+			if kept.Len() < keep {
+				heap.Push(kept, pair)
+			} else if keep > 0 && kept.better(pair, kept.pairs[0]) {
+				kept.pairs[0] = pair
+				heap.Fix(kept, 0)
+			}
+		}
+	}
+
+	sort.Slice(kept.pairs, func(i, j int) bool {
+		return kept.better(kept.pairs[i], kept.pairs[j])
+	})
+	trips := make([]structs.RoundTrip, 0, len(kept.pairs))
+	for _, pair := range kept.pairs {
+		there, back := outbound[pair.there], inbound[pair.back]
+		totalPrice := routePrice(there) + routePrice(back)
+		trips = append(trips, structs.RoundTrip{
+			Outbound:        there,
+			Return:          back,
+			TotalPrice:      fmt.Sprintf("%.2f", totalPrice),
+			TotalPriceMinor: pair.priceMinor,
+			StaySeconds:     int64(routeDeparture(back).Sub(routeArrival(there)) / time.Second),
+		})
+	}
+	return trips, total
+}
+
+// An outbound and a return route by their index, with their price in minor units
+type routePair struct {
+	there      int
+	back       int
+	priceMinor int64
+}
+
+// Max-heap of the pairs kept so far, the worst one on top to be replaced by a better pair
+type pairHeap struct {
+	outbound []structs.PossibleRoute
+	inbound  []structs.PossibleRoute
+	pairs    []routePair
+}
+
+// Cheaper pairs come first, then the earlier outbound departure, then the order the routes were found in
+func (h *pairHeap) better(a routePair, b routePair) bool {
+	if a.priceMinor != b.priceMinor {
+		return a.priceMinor < b.priceMinor
+	}
+	departureA, departureB := routeDeparture(h.outbound[a.there]), routeDeparture(h.outbound[b.there])
+	if !departureA.Equal(departureB) {
+		return departureA.Before(departureB)
+	}
+	if a.there != b.there {
+		return a.there < b.there
+	}
+	return a.back < b.back
+}
+
+func (h *pairHeap) Len() int           { return len(h.pairs) }
+func (h *pairHeap) Less(i, j int) bool { return h.better(h.pairs[j], h.pairs[i]) }
+func (h *pairHeap) Swap(i, j int)      { h.pairs[i], h.pairs[j] = h.pairs[j], h.pairs[i] }
+func (h *pairHeap) Push(x interface{}) { h.pairs = append(h.pairs, x.(routePair)) }
+func (h *pairHeap) Pop() interface{} {
+	last := h.pairs[len(h.pairs)-1]
+	h.pairs = h.pairs[:len(h.pairs)-1]
+	return last
+}
+
+// PageRoundTrips returns the requested page of round trips, limit 0 meaning all of them
+func PageRoundTrips(trips []structs.RoundTrip, limit int, offset int) []structs.RoundTrip {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(trips) {
+		return []structs.RoundTrip{}
+	}
+	trips = trips[offset:]
+	if limit > 0 && len(trips) > limit {
+		trips = trips[:limit]
+	}
+	return trips
+}
@@ -0,0 +1,153 @@
+package calculations
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// The cross product PairRoundTrips replaced, building and sorting every pair
+func crossProductRoundTrips(outbound []structs.PossibleRoute, inbound []structs.PossibleRoute, minStay time.Duration) []structs.RoundTrip {
+	trips := []structs.RoundTrip{}
+	for _, there := range outbound {
+		for _, back := range inbound {
+			stay := routeDeparture(back).Sub(routeArrival(there))
+			if stay <= 0 || stay < minStay {
+				continue
+			}
+			totalPrice := routePrice(there) + routePrice(back)
+			trips = append(trips, structs.RoundTrip{
+				Outbound:        there,
+				Return:          back,
+				TotalPrice:      fmt.Sprintf("%.2f", totalPrice),
+				TotalPriceMinor: int64(math.Round(totalPrice * 100)),
+				StaySeconds:     int64(stay / time.Second),
+			})
+		}
+	}
+	sort.SliceStable(trips, func(i, j int) bool {
+		if trips[i].TotalPriceMinor != trips[j].TotalPriceMinor {
+			return trips[i].TotalPriceMinor < trips[j].TotalPriceMinor
+		}
+		return routeDeparture(trips[i].Outbound).Before(routeDeparture(trips[j].Outbound))
+	})
+	return trips
+}
+
+// One route per provider, so that many pairs cost the same and the order of ties is tested too
+func singleLegRoutes(providers []structs.SimplifiedProvider) []structs.PossibleRoute {
+	routes := make([]structs.PossibleRoute, len(providers))
+	for i, provider := range providers {
+		routes[i] = structs.PossibleRoute{Providers: []structs.SimplifiedProvider{provider}}
+	}
+	return routes
+}
+
+func tripIDs(trips []structs.RoundTrip) string {
+	var ids string
+	for _, trip := range trips {
+		ids += fmt.Sprintf("%s+%s/%d/%s ", trip.Outbound.Providers[0].ID, trip.Return.Providers[0].ID, trip.StaySeconds, trip.TotalPrice)
+	}
+	return ids
+}
+
+func TestPairRoundTripsMatchesCrossProduct(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		legs := generateLegs(2, 60, seed)
+		outbound, inbound := singleLegRoutes(legs[0]), singleLegRoutes(legs[1])
+		for _, minStay := range []time.Duration{0, 72 * time.Hour} {
+			want := crossProductRoundTrips(outbound, inbound, minStay)
+			for _, keep := range []int{0, 1, 7, 100, len(want), len(want) + 1} {
+				trips, total := PairRoundTrips(outbound, inbound, minStay, keep)
+				if total != len(want) {
+					t.Fatalf("seed %d, minStay %s: counted %d pairs, want %d", seed, minStay, total, len(want))
+				}
+				prefix := want
+				if keep < len(prefix) {
+					prefix = prefix[:keep]
+				}
+				if tripIDs(trips) != tripIDs(prefix) {
+					t.Fatalf("seed %d, minStay %s, keep %d:\n got %s\nwant %s", seed, minStay, keep, tripIDs(trips), tripIDs(prefix))
+				}
+			}
+		}
+	}
+}
+
+func TestPairRoundTripsMinStay(t *testing.T) {
+	landing := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	flight := func(id string, departure time.Time) structs.SimplifiedProvider {
+		return structs.SimplifiedProvider{ID: id, Price: 100, FlightStart: departure, FlightEnd: departure.Add(time.Hour)}
+	}
+	outbound := singleLegRoutes([]structs.SimplifiedProvider{flight("there", landing.Add(-time.Hour))})
+	inbound := singleLegRoutes([]structs.SimplifiedProvider{
+		flight("before", landing.Add(-time.Minute)),
+		flight("atLanding", landing),
+		flight("second", landing.Add(time.Second)),
+		flight("day", landing.Add(24*time.Hour)),
+		flight("threeDays", landing.Add(72*time.Hour)),
+	})
+	// An empty route is never paired
+	inbound = append(inbound, structs.PossibleRoute{})
+
+	tests := []struct {
+		minStay time.Duration
+		want    []string
+	}{
+		{0, []string{"second", "day", "threeDays"}},
+		{time.Second, []string{"second", "day", "threeDays"}},
+		{24 * time.Hour, []string{"day", "threeDays"}},
+		{72 * time.Hour, []string{"threeDays"}},
+		{72*time.Hour + time.Second, nil},
+	}
+	for _, test := range tests {
+		trips, total := PairRoundTrips(outbound, inbound, test.minStay, 10)
+		var got []string
+		for _, trip := range trips {
+			got = append(got, trip.Return.Providers[0].ID)
+			stay := trip.Return.Providers[0].FlightStart.Sub(landing)
+			if trip.StaySeconds != int64(stay/time.Second) || trip.TotalPriceMinor != 20000 || trip.TotalPrice != "200.00" {
+				t.Fatalf("minStay %s: %s stays %d seconds for %s, want %d seconds for 200.00",
+					test.minStay, got[len(got)-1], trip.StaySeconds, trip.TotalPrice, int64(stay/time.Second))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) || total != len(test.want) {
+			t.Fatalf("minStay %s paired %v of %d, want %v", test.minStay, got, total, test.want)
+		}
+	}
+}
+
+func TestPageRoundTrips(t *testing.T) {
+	trips := make([]structs.RoundTrip, 5)
+	for i := range trips {
+		trips[i].StaySeconds = int64(i)
+	}
+	tests := []struct {
+		limit, offset int
+		want          string
+	}{
+		{0, 0, "[0 1 2 3 4]"},
+		{2, 0, "[0 1]"},
+		{2, 3, "[3 4]"},
+		{2, 4, "[4]"},
+		{10, 1, "[1 2 3 4]"},
+		{0, 4, "[4]"},
+		{0, 5, "[]"},
+		{2, 100, "[]"},
+		{-1, 0, "[0 1 2 3 4]"},
+		{2, -1, "[0 1]"},
+	}
+	for _, test := range tests {
+		page := PageRoundTrips(trips, test.limit, test.offset)
+		stays := []int64{}
+		for _, trip := range page {
+			stays = append(stays, trip.StaySeconds)
+		}
+		if fmt.Sprint(stays) != test.want || page == nil {
+			t.Fatalf("limit %d, offset %d returned %v, want %s", test.limit, test.offset, stays, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package calculations
+
+import (
+	"sort"
+	"space-travel/structs"
+	"time"
+)
+
+// SearchMode selects which itineraries are returned for a route
+type SearchMode string
+
+const (
+	// Earliest arrival for every departure of the first leg
+	EarliestArrivalMode SearchMode = "earliest"
+	// Itineraries not beaten on price, duration and number of companies at once
+	ParetoMode SearchMode = "pareto"
+)
+
+// ParseSearchMode maps a request parameter to a SearchMode, defaulting to EarliestArrivalMode
+func ParseSearchMode(value string) (SearchMode, bool) {
+	switch SearchMode(value) {
+	case "", EarliestArrivalMode:
+		return EarliestArrivalMode, true
+	case ParetoMode:
+		return ParetoMode, true
+	}
+	return "", false
+}
+
+// SearchOptions controls how routes between two planets are searched
+type SearchOptions struct {
+	MaxPaths   int
+	Mode       SearchMode
+	Connection ConnectionRule
+	// Planets to visit on the way, in order
+	Stops []Stop
+	// Whether results may be read from and stored in the route cache
+	Cacheable bool
+}
+
+// ConnectionRule limits the time spent waiting between landing and the next departure
+type ConnectionRule struct {
+	MinConnection time.Duration
+	// Zero means a layover can be as long as the pricelist allows
+	MaxLayover time.Duration
+}
+
+// Allows reports whether a traveller landing with previous can board next
+func (c ConnectionRule) Allows(previous structs.SimplifiedProvider, next structs.SimplifiedProvider) bool {
+	return !c.departsTooEarly(previous, next) && !c.departsTooLate(previous, next)
+}
+
+func (c ConnectionRule) departsTooEarly(previous structs.SimplifiedProvider, next structs.SimplifiedProvider) bool {
+	return !timesMatch(previous, next) || next.FlightStart.Sub(previous.FlightEnd) < c.MinConnection
+}
+
+func (c ConnectionRule) departsTooLate(previous structs.SimplifiedProvider, next structs.SimplifiedProvider) bool {
+	return c.MaxLayover > 0 && next.FlightStart.Sub(previous.FlightEnd) > c.MaxLayover
+}
+
+// Rules for the connections between consecutive legs, one fewer than the number of legs
+func uniformRules(rule ConnectionRule, nrOfJumps int) []ConnectionRule {
+	if nrOfJumps < 2 {
+		return nil
+	}
+	rules := make([]ConnectionRule, nrOfJumps-1)
+	for i := range rules {
+		rules[i] = rule
+	}
+	return rules
+}
+
+// EarliestArrivals runs an earliest-arrival search from every departure of the first leg.
+// Each departure is followed by the connections that land the earliest at the destination
+// while respecting rules[i] between leg i and leg i+1, and itineraries that leave earlier
+// but do not arrive sooner than another one are dropped.
+// The result holds one provider index per leg, ordered by departure time.
+func EarliestArrivals(providers [][]structs.SimplifiedProvider, rules []ConnectionRule) [][]int {
+	nrOfJumps := len(providers)
+	if nrOfJumps == 0 {
+		return nil
+	}
+
+	// arrival[leg][i] is the earliest landing at the destination when boarding providers[leg][i],
+	// next[leg][i] the provider of the following leg that achieves it
+	arrival := make([][]time.Time, nrOfJumps)
+	reachable := make([][]bool, nrOfJumps)
+	next := make([][]int, nrOfJumps)
+
+	last := nrOfJumps - 1
+	arrival[last] = make([]time.Time, len(providers[last]))
+	reachable[last] = make([]bool, len(providers[last]))
+	for i, provider := range providers[last] {
+		arrival[last][i] = provider.FlightEnd
+		reachable[last][i] = true
+	}
+
+	for leg := last - 1; leg >= 0; leg-- {
+		arrival[leg], reachable[leg], next[leg] = bestConnections(providers[leg], providers[leg+1], arrival[leg+1], reachable[leg+1], rules[leg])
+	}
+
+	var itineraries [][]int
+	for first := range providers[0] {
+		if !reachable[0][first] {
+			continue
+		}
+		itinerary := make([]int, nrOfJumps)
+		itinerary[0] = first
+		for leg := 1; leg < nrOfJumps; leg++ {
+			itinerary[leg] = next[leg-1][itinerary[leg-1]]
+		}
+		itineraries = append(itineraries, itinerary)
+	}
+
+	return dropDominatedDepartures(providers, itineraries)
+}
+
+// For every flight of a leg picks the allowed connection on the next leg with the earliest final arrival.
+// Flights are visited by landing time so the window of allowed departures only moves forward,
+// which lets a monotonic queue keep the best connection of the current window at its front.
+func bestConnections(current []structs.SimplifiedProvider, following []structs.SimplifiedProvider,
+	followingArrival []time.Time, followingReachable []bool, rule ConnectionRule) ([]time.Time, []bool, []int) {
+	arrival := make([]time.Time, len(current))
+	reachable := make([]bool, len(current))
+	next := make([]int, len(current))
+
+	byEnd := sortedIndexes(len(current), func(i, j int) bool {
+		return current[i].FlightEnd.Before(current[j].FlightEnd)
+	})
+	byStart := sortedIndexes(len(following), func(i, j int) bool {
+		return following[i].FlightStart.Before(following[j].FlightStart)
+	})
+
+	var window []int // positions in byStart, increasing, with increasing arrival
+	added, earliestAllowed := 0, 0
+	for _, i := range byEnd {
+		previous := current[i]
+
+		// Departures that leave too late for this flight may fit a later one, so only add the ones in range
+		for added < len(byStart) && !rule.departsTooLate(previous, following[byStart[added]]) {
+			if followingReachable[byStart[added]] {
+				for len(window) > 0 && !followingArrival[byStart[window[len(window)-1]]].Before(followingArrival[byStart[added]]) {
+					window = window[:len(window)-1]
+				}
+				window = append(window, added)
+			}
+			added++
+		}
+
+		// Departures that leave too early for this flight are too early for every later one as well
+		for earliestAllowed < len(byStart) && rule.departsTooEarly(previous, following[byStart[earliestAllowed]]) {
+			earliestAllowed++
+		}
+		for len(window) > 0 && window[0] < earliestAllowed {
+			window = window[1:]
+		}
+
+		if len(window) > 0 {
+			best := byStart[window[0]]
+			arrival[i] = followingArrival[best]
+			reachable[i] = true
+			next[i] = best
+		}
+	}
+
+	return arrival, reachable, next
+}
+
+func sortedIndexes(n int, less func(i, j int) bool) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return less(indexes[i], indexes[j])
+	})
+	return indexes
+}
+
+// Keeps only itineraries for which no later (or equal) departure arrives at the same time or sooner
+func dropDominatedDepartures(providers [][]structs.SimplifiedProvider, itineraries [][]int) [][]int {
+	last := len(providers) - 1
+	departure := func(itinerary []int) structs.SimplifiedProvider { return providers[0][itinerary[0]] }
+	arrival := func(itinerary []int) structs.SimplifiedProvider { return providers[last][itinerary[last]] }
+
+	// Latest departures first, earliest arrival first among equal departures
+	sort.SliceStable(itineraries, func(i, j int) bool {
+		startI, startJ := departure(itineraries[i]).FlightStart, departure(itineraries[j]).FlightStart
+		if !startI.Equal(startJ) {
+			return startI.After(startJ)
+		}
+		return arrival(itineraries[i]).FlightEnd.Before(arrival(itineraries[j]).FlightEnd)
+	})
+
+	var optimal [][]int
+	for _, itinerary := range itineraries {
+		if len(optimal) == 0 || arrival(itinerary).FlightEnd.Before(arrival(optimal[len(optimal)-1]).FlightEnd) {
+			optimal = append(optimal, itinerary)
+		}
+	}
+
+	// Back to departure order
+	for i, j := 0, len(optimal)-1; i < j; i, j = i+1, j-1 {
+		optimal[i], optimal[j] = optimal[j], optimal[i]
+	}
+	return optimal
+}
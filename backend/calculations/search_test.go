@@ -0,0 +1,129 @@
+package calculations
+
+import (
+	"fmt"
+	"math/rand"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// Random flights spread over a month, so that about half of the pairs on consecutive legs connect
+func generateLegs(legs int, perLeg int, seed int64) [][]structs.SimplifiedProvider {
+	rng := rand.New(rand.NewSource(seed))
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	providers := make([][]structs.SimplifiedProvider, legs)
+	for leg := range providers {
+		for i := 0; i < perLeg; i++ {
+			start := base.Add(time.Duration(leg*24*60+rng.Intn(30*24*60)) * time.Minute)
+			providers[leg] = append(providers[leg], structs.SimplifiedProvider{
+				ID:          fmt.Sprintf("%d-%d", leg, i),
+				CompanyID:   fmt.Sprintf("company-%d", rng.Intn(8)),
+				Price:       float64(100 + rng.Intn(900)),
+				FlightStart: start,
+				FlightEnd:   start.Add(time.Duration(60+rng.Intn(48*60)) * time.Minute),
+			})
+		}
+	}
+	return providers
+}
+
+// The permutation enumeration the earliest-arrival search replaced, kept as a reference
+func loop(providers [][]structs.SimplifiedProvider) [][]int {
+	var nrOfJumps = len(providers)
+	var permutationsArray = make([][]int, 0)
+	var counterArray = make([]int, nrOfJumps)
+
+	var generatePermutations func(int)
+	generatePermutations = func(pos int) {
+		if pos == nrOfJumps {
+			tmp := make([]int, nrOfJumps)
+			copy(tmp, counterArray)
+			permutationsArray = append(permutationsArray, tmp)
+			return
+		}
+		for i := range providers[pos] {
+			if pos == 0 || timesMatch(providers[pos-1][counterArray[pos-1]], providers[pos][i]) {
+				counterArray[pos] = i
+				generatePermutations(pos + 1)
+			}
+		}
+	}
+
+	generatePermutations(0)
+	return permutationsArray
+}
+
+// Departure and arrival of every itinerary, which is what both searches have to agree on
+// since equally good connections may be picked differently
+func itineraryTimes(providers [][]structs.SimplifiedProvider, itineraries [][]int) []string {
+	last := len(providers) - 1
+	times := make([]string, len(itineraries))
+	for i, itinerary := range itineraries {
+		times[i] = providers[0][itinerary[0]].FlightStart.String() + " - " + providers[last][itinerary[last]].FlightEnd.String()
+	}
+	return times
+}
+
+func TestEarliestArrivalsMatchesLoop(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		legs := 1 + int(seed%4)
+		providers := generateLegs(legs, 1+int(seed%12), seed)
+
+		expected := itineraryTimes(providers, dropDominatedDepartures(providers, loop(providers)))
+		got := itineraryTimes(providers, EarliestArrivals(providers, uniformRules(ConnectionRule{}, legs)))
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Fatalf("seed %d: got %v, want %v", seed, got, expected)
+		}
+	}
+}
+
+func BenchmarkEarliestArrivals(b *testing.B) {
+	for _, perLeg := range []int{100, 200, 500} {
+		providers := generateLegs(3, perLeg, 1)
+		rules := uniformRules(ConnectionRule{}, len(providers))
+
+		b.Run(fmt.Sprintf("search/providers=%d", perLeg), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				EarliestArrivals(providers, rules)
+			}
+		})
+		// Enumerating every permutation runs out of memory well before 500 providers per leg
+		if perLeg > 200 {
+			continue
+		}
+		b.Run(fmt.Sprintf("loop/providers=%d", perLeg), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				dropDominatedDepartures(providers, loop(providers))
+			}
+		})
+	}
+}
+
+func TestConnectionRuleBoundaries(t *testing.T) {
+	landing := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	previous := structs.SimplifiedProvider{FlightStart: landing.Add(-time.Hour), FlightEnd: landing}
+	rule := ConnectionRule{MinConnection: time.Hour, MaxLayover: 3 * time.Hour}
+	tests := []struct {
+		layover time.Duration
+		rule    ConnectionRule
+		allowed bool
+	}{
+		{time.Hour - time.Second, rule, false},
+		{time.Hour, rule, true},
+		{3 * time.Hour, rule, true},
+		{3*time.Hour + time.Second, rule, false},
+		// Without a minimum the next flight still has to leave after landing
+		{0, ConnectionRule{}, false},
+		{time.Second, ConnectionRule{}, true},
+		{-time.Hour, ConnectionRule{}, false},
+		// Zero means no maximum
+		{1000 * time.Hour, ConnectionRule{MinConnection: time.Hour}, true},
+	}
+	for _, test := range tests {
+		next := structs.SimplifiedProvider{FlightStart: landing.Add(test.layover), FlightEnd: landing.Add(test.layover + time.Hour)}
+		if got := test.rule.Allows(previous, next); got != test.allowed {
+			t.Fatalf("layover of %s under %+v is allowed %v, want %v", test.layover, test.rule, got, test.allowed)
+		}
+	}
+}
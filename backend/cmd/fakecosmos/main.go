@@ -0,0 +1,238 @@
+// Command fakecosmos serves randomly generated pricelists in the format of the
+// Cosmos Odyssey TravelPrices API, so ingestion can be exercised without network.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"space-travel/structs"
+	"sync"
+	"time"
+)
+
+// Legs published upstream with their distances in kilometres
+var upstreamLegs = []struct {
+	from, to string
+	distance int
+}{
+	{"Mercury", "Venus", 50290000},
+	{"Venus", "Mercury", 50290000},
+	{"Venus", "Earth", 41400000},
+	{"Earth", "Jupiter", 628730000},
+	{"Earth", "Uranus", 2723950000},
+	{"Mars", "Venus", 119740000},
+	{"Jupiter", "Mars", 550390000},
+	{"Jupiter", "Venus", 740980000},
+	{"Saturn", "Earth", 1275000000},
+	{"Saturn", "Neptune", 3076400000},
+	{"Uranus", "Saturn", 1448950000},
+	{"Uranus", "Neptune", 1627450000},
+	{"Neptune", "Uranus", 1627450000},
+	{"Neptune", "Mercury", 4443090000},
+}
+
+var companyNames = []string{
+	"Space Odyssey", "Explore Dynamite", "Space Voyager", "Travel Nova", "Galaxy Express",
+	"Space Piper", "Spacelux", "Spacegenix", "Explore Origin", "SpaceX",
+}
+
+type options struct {
+	seed         int64
+	validFor     time.Duration
+	minProviders int
+	maxProviders int
+	failRate     float64
+	timeoutRate  float64
+	malformRate  float64
+	timeout      time.Duration
+	recordDir    string
+}
+
+// Server hands out the current pricelist and generates the next one once it expires
+type server struct {
+	opts options
+
+	mu         sync.Mutex
+	generation int64
+	current    structs.Pricelist
+	failures   *rand.Rand
+}
+
+func main() {
+	var opts options
+	addr := flag.String("addr", ":8081", "address to listen on")
+	flag.Int64Var(&opts.seed, "seed", 1, "seed for the generated pricelists and injected failures")
+	flag.DurationVar(&opts.validFor, "valid-for", 15*time.Minute, "how long each pricelist stays valid")
+	flag.IntVar(&opts.minProviders, "min-providers", 3, "fewest providers per leg")
+	flag.IntVar(&opts.maxProviders, "max-providers", 15, "most providers per leg")
+	flag.Float64Var(&opts.failRate, "fail-rate", 0, "share of requests answered with 500")
+	flag.Float64Var(&opts.timeoutRate, "timeout-rate", 0, "share of requests that hang for -timeout")
+	flag.Float64Var(&opts.malformRate, "malformed-rate", 0, "share of requests answered with truncated JSON")
+	flag.DurationVar(&opts.timeout, "timeout", time.Minute, "how long a hanging request waits before giving up")
+	flag.StringVar(&opts.recordDir, "record", "", "directory to save every generated pricelist in")
+	flag.Parse()
+
+	if opts.minProviders < 1 || opts.maxProviders < opts.minProviders {
+		log.Fatal("need 1 <= -min-providers <= -max-providers")
+	}
+	if opts.recordDir != "" {
+		if err := os.MkdirAll(opts.recordDir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	s := &server{
+		opts:     opts,
+		failures: rand.New(rand.NewSource(opts.seed)),
+	}
+	http.HandleFunc("/api/v1.0/TravelPrices", s.handleTravelPrices)
+
+	log.Println("Serving fake TravelPrices on " + *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (s *server) handleTravelPrices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	roll := s.failures.Float64()
+	list, err := s.currentPricelist(time.Now())
+	s.mu.Unlock()
+	if err != nil {
+		log.Println("error: ", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Failures are picked from consecutive slices of a single roll so the rates add up
+	switch {
+	case roll < s.opts.timeoutRate:
+		log.Println("Injecting timeout")
+		select {
+		case <-time.After(s.opts.timeout):
+		case <-r.Context().Done():
+		}
+		http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		return
+	case roll < s.opts.timeoutRate+s.opts.failRate:
+		log.Println("Injecting 500")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		log.Println("error: ", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if roll < s.opts.timeoutRate+s.opts.failRate+s.opts.malformRate {
+		log.Println("Injecting malformed JSON")
+		body = body[:len(body)/2]
+	}
+
+	// The pricelist ID doubles as ETag so clients can make conditional requests
+	etag := `"` + list.ID + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Returns the pricelist valid at now, generating the next one when the current one has expired
+func (s *server) currentPricelist(now time.Time) (structs.Pricelist, error) {
+	if s.current.ID != "" && now.Before(s.current.ValidUntil) {
+		return s.current, nil
+	}
+
+	s.generation++
+	s.current = generatePricelist(s.opts, s.generation, now)
+	log.Printf("Generated pricelist %s valid until %s", s.current.ID, s.current.ValidUntil.Format(time.RFC3339))
+
+	if s.opts.recordDir != "" {
+		if err := record(s.opts.recordDir, s.generation, s.current); err != nil {
+			return structs.Pricelist{}, err
+		}
+	}
+	return s.current, nil
+}
+
+// Apart from the times, which count from now, a pricelist only depends on the seed and its generation
+func generatePricelist(opts options, generation int64, now time.Time) structs.Pricelist {
+	rng := rand.New(rand.NewSource(opts.seed*1000003 + generation))
+	newID := func() string {
+		id, err := uuid.NewRandomFromReader(rng)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return id.String()
+	}
+
+	created := now.Truncate(time.Second)
+	list := structs.Pricelist{
+		ID:         newID(),
+		ValidUntil: created.Add(opts.validFor).UTC(),
+	}
+
+	locations := map[string]structs.Location{}
+	location := func(name string) structs.Location {
+		if _, ok := locations[name]; !ok {
+			locations[name] = structs.Location{ID: newID(), Name: name}
+		}
+		return locations[name]
+	}
+	companies := make([]structs.Company, len(companyNames))
+	for i, name := range companyNames {
+		companies[i] = structs.Company{ID: newID(), Name: name}
+	}
+
+	for _, upstream := range upstreamLegs {
+		leg := structs.Leg{
+			ID: newID(),
+			RouteInfo: structs.RouteInfo{
+				ID:       newID(),
+				From:     location(upstream.from),
+				To:       location(upstream.to),
+				Distance: upstream.distance,
+			},
+		}
+
+		count := opts.minProviders + rng.Intn(opts.maxProviders-opts.minProviders+1)
+		for i := 0; i < count; i++ {
+			// Ships cruise between 20 and 80 million km/h and depart within the next thirty days
+			speed := 2e7 + rng.Float64()*6e7
+			flightTime := time.Duration(float64(upstream.distance) / speed * float64(time.Hour)).Round(time.Minute)
+			start := created.Add(time.Duration(rng.Intn(30*24*60)) * time.Minute).UTC()
+			price := float64(upstream.distance)/1e7*(0.5+rng.Float64()*1.5) + 50
+
+			leg.Providers = append(leg.Providers, structs.Provider{
+				ID:          newID(),
+				Company:     companies[rng.Intn(len(companies))],
+				Price:       float64(int(price*100)) / 100,
+				FlightStart: start,
+				FlightEnd:   start.Add(flightTime),
+			})
+		}
+		list.Legs = append(list.Legs, leg)
+	}
+
+	return list
+}
+
+func record(dir string, generation int64, list structs.Pricelist) error {
+	body, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%06d-%s.json", generation, list.ID))
+	return os.WriteFile(path, body, 0o644)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTravelPricesURL = "https://cosmos-odyssey.azurewebsites.net/api/v1.0/TravelPrices"
+	defaultSQLitePath      = "./database/pricelists.db"
+)
+
+// Config holds the settings that can be changed without a code change
+type Config struct {
+	// Storage backend: "sqlite", "postgres" or "memory"
+	DatabaseDriver string
+	// SQLite database file or PostgreSQL connection string
+	DatabaseURL string
+	// Where pricelists come from: "http", "file" or "dir"
+	PricelistSource string
+	// URL for the http source, path of the JSON file or directory otherwise
+	PricelistLocation string
+	// How long an expired pricelist replayed by the file and dir sources is made valid for, 0 keeps its times
+	PricelistReplayValidFor time.Duration
+	// Most pricelists kept, 0 means no limit
+	RetentionCount int
+	// How long a pricelist is kept after it expired, 0 means no limit
+	RetentionAge time.Duration
+	// Wait after the first failed fetch, doubled per further failure up to FetchMaxBackoff
+	FetchInitialBackoff time.Duration
+	FetchMaxBackoff     time.Duration
+	// Share by which fetch waits are randomly stretched or shortened
+	FetchJitter float64
+	// Consecutive failed fetches that open the circuit breaker, 0 disables it
+	FetchFailureThreshold int
+	// How long the open circuit breaker waits before a trial fetch
+	FetchBreakerCooldown time.Duration
+	// How long before validUntil the next pricelist is fetched
+	FetchRefreshMargin time.Duration
+	// Maximum number of planet paths searched per request, 0 means all of them
+	MaxRoutePaths int
+	// Most round trips a search pages through, cheapest first
+	MaxRoundTrips int
+	// Shortest allowed time between landing and the next departure
+	MinConnection time.Duration
+	// Longest allowed wait between two legs, 0 means no limit
+	MaxLayover time.Duration
+	// Bearer token for the staff booking listing, which is disabled while it is empty
+	AdminToken string
+	// Seats on every provider flight
+	SeatCapacity int
+	// Seats on the flights of a company, by company name, replacing SeatCapacity
+	CompanySeatCapacity map[string]int
+	// How long a hold keeps its seats and price
+	HoldDuration time.Duration
+	// How often holds that ran out are released, 0 disables releasing them
+	HoldSweepInterval time.Duration
+	// How long the response to a request with an Idempotency-Key is replayed
+	IdempotencyKeyRetention time.Duration
+	// How long a request with an Idempotency-Key may run before its key can be claimed again
+	IdempotencyKeyLease time.Duration
+}
+
+// Load reads the configuration from environment variables, falling back to defaults
+func Load() Config {
+	source := stringFromEnv("PRICELIST_SOURCE", "http")
+	location := os.Getenv("PRICELIST_LOCATION")
+	if location == "" && source == "http" {
+		location = defaultTravelPricesURL
+	}
+
+	driver := stringFromEnv("DATABASE_DRIVER", "sqlite")
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" && driver == "sqlite" {
+		databaseURL = defaultSQLitePath
+	}
+
+	return Config{
+		DatabaseDriver:          driver,
+		DatabaseURL:             databaseURL,
+		PricelistSource:         source,
+		PricelistLocation:       location,
+		PricelistReplayValidFor: durationFromEnv("PRICELIST_REPLAY_VALID_FOR", 15*time.Minute),
+		RetentionCount:          intFromEnv("PRICELIST_RETENTION_COUNT", 15),
+		RetentionAge:            durationFromEnv("PRICELIST_RETENTION_AGE", 0),
+		FetchInitialBackoff:     durationFromEnv("FETCH_INITIAL_BACKOFF", 5*time.Second),
+		FetchMaxBackoff:         durationFromEnv("FETCH_MAX_BACKOFF", 5*time.Minute),
+		FetchJitter:             floatFromEnv("FETCH_JITTER", 0.2),
+		FetchFailureThreshold:   intFromEnv("FETCH_FAILURE_THRESHOLD", 8),
+		FetchBreakerCooldown:    durationFromEnv("FETCH_BREAKER_COOLDOWN", 10*time.Minute),
+		FetchRefreshMargin:      durationFromEnv("FETCH_REFRESH_MARGIN", 30*time.Second),
+		MaxRoutePaths:           intFromEnv("MAX_ROUTE_PATHS", 0),
+		MaxRoundTrips:           intFromEnv("MAX_ROUND_TRIPS", 1000),
+		MinConnection:           durationFromEnv("MIN_CONNECTION", 0),
+		MaxLayover:              durationFromEnv("MAX_LAYOVER", 0),
+		AdminToken:              os.Getenv("ADMIN_TOKEN"),
+		SeatCapacity:            intFromEnv("SEAT_CAPACITY", 100),
+		CompanySeatCapacity:     capacitiesFromEnv("COMPANY_SEAT_CAPACITY"),
+		HoldDuration:            durationFromEnv("HOLD_DURATION", 15*time.Minute),
+		HoldSweepInterval:       durationFromEnv("HOLD_SWEEP_INTERVAL", time.Minute),
+		IdempotencyKeyRetention: durationFromEnv("IDEMPOTENCY_KEY_RETENTION", 24*time.Hour),
+		IdempotencyKeyLease:     durationFromEnv("IDEMPOTENCY_KEY_LEASE", time.Minute),
+	}
+}
+
+func stringFromEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func floatFromEnv(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid value %q for %s, using %g", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid value %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// Reads a list like "Space Voyager=20,Galaxy Express=150", skipping invalid entries
+func capacitiesFromEnv(key string) map[string]int {
+	capacities := map[string]int{}
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(item, "=")
+		seats, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || seats < 0 {
+			log.Printf("Invalid entry %q in %s, skipping it", item, key)
+			continue
+		}
+		capacities[strings.TrimSpace(name)] = seats
+	}
+	return capacities
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"space-travel/calculations"
+	"space-travel/structs"
+	"space-travel/validation"
+	"strings"
+	"time"
+)
+
+// Booking statuses
+const (
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+)
+
+var (
+	ErrUnknownBooking   = errors.New("Unknown booking")
+	ErrAlreadyCancelled = errors.New("Booking is already cancelled")
+)
+
+// BookingFilter selects bookings for the staff listing. Zero values disable a filter.
+type BookingFilter struct {
+	// Part of the full name of any passenger, in any case
+	Passenger   string
+	From        string
+	Destination string
+	Company     string
+	// Travel date as YYYY-MM-DD
+	Date   string
+	Status string
+	Limit  int
+	Offset int
+}
+
+func (f BookingFilter) matches(booking structs.Booking) bool {
+	if f.Passenger != "" && !hasPassenger(booking.Passengers, f.Passenger) {
+		return false
+	}
+	if f.From != "" && booking.Routes.From != f.From {
+		return false
+	}
+	if f.Destination != "" && booking.Routes.Destination != f.Destination {
+		return false
+	}
+	if f.Company != "" && !containsString(booking.CompanyNames, f.Company) {
+		return false
+	}
+	if f.Date != "" && !strings.HasPrefix(booking.StartTime, f.Date) {
+		return false
+	}
+	if f.Status != "" && booking.Status != f.Status {
+		return false
+	}
+	return true
+}
+
+func hasPassenger(passengers []structs.Passenger, name string) bool {
+	for _, passenger := range passengers {
+		if strings.Contains(strings.ToLower(passenger.FirstName+" "+passenger.LastName), strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Letters and digits that cannot be mistaken for each other when read out
+const referenceAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Returns a random eight character booking reference
+func newBookingReference() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	reference := make([]byte, len(random))
+	for i, b := range random {
+		reference[i] = referenceAlphabet[int(b)%len(referenceAlphabet)]
+	}
+	return string(reference), nil
+}
+
+// Gives a booking that is about to be stored its reference and status
+func newConfirmedBooking(booking structs.Booking, now time.Time) (structs.Booking, error) {
+	reference, err := newBookingReference()
+	if err != nil {
+		return structs.Booking{}, err
+	}
+	booking.Reference = reference
+	booking.Status = BookingConfirmed
+	booking.BookedAt = now.UTC()
+	booking.CancelledAt = nil
+	return booking, nil
+}
+
+// PrepareBooking checks a booking request against the pricelist it references and fills in
+// legs, companies, times and price from the stored providers instead of trusting the client.
+// It returns validation.FieldErrors for invalid requests and ErrPricelistExpired when the
+// pricelist can no longer be booked.
+func PrepareBooking(store Store, booking structs.Booking, now time.Time) (structs.Booking, error) {
+	return prepareBooking(store, booking, now, true)
+}
+
+func prepareBooking(store Store, booking structs.Booking, now time.Time, namesRequired bool) (structs.Booking, error) {
+	var problems validation.FieldErrors
+	if len(booking.Passengers) == 0 {
+		// Requests without a passenger list book for a single passenger
+		if namesRequired && strings.TrimSpace(booking.FirstName) == "" {
+			problems.Add("firstName", "is required")
+		}
+		if namesRequired && strings.TrimSpace(booking.LastName) == "" {
+			problems.Add("lastName", "is required")
+		}
+		booking.Passengers = []structs.Passenger{{FirstName: booking.FirstName, LastName: booking.LastName}}
+	} else {
+		checkPassengers(booking.Passengers, namesRequired, &problems)
+	}
+	if booking.PricelistID == "" {
+		problems.Add("pricelistID", "is required")
+		return booking, problems.Err()
+	}
+
+	pricelist, err := store.Pricelist(booking.PricelistID)
+	if errors.Is(err, ErrNoPricelist) {
+		problems.Add("pricelistID", fmt.Sprintf("pricelist %s does not exist", booking.PricelistID))
+		return booking, problems.Err()
+	}
+	if err != nil {
+		return booking, err
+	}
+	if !pricelist.ValidUntil.After(now) {
+		return booking, ErrPricelistExpired
+	}
+
+	legs, err := bookedLegs(store, pricelist.ID, booking.ProviderIDs, "providerIDs", &problems)
+	if err != nil {
+		return booking, err
+	}
+	var returnLegs []structs.BookedLeg
+	if booking.Return != nil {
+		returnLegs, err = bookedLegs(store, pricelist.ID, booking.Return.ProviderIDs, "return.providerIDs", &problems)
+		if err != nil {
+			return booking, err
+		}
+		if len(legs) > 0 && len(returnLegs) > 0 {
+			// The return may start from another planet, as open-jaw trips found with returnFrom do
+			landing := legs[len(legs)-1]
+			if !returnLegs[0].FlightStart.After(landing.FlightEnd) {
+				problems.Add("return.providerIDs[0]", fmt.Sprintf("departs at %s before the outbound trip lands at %s",
+					returnLegs[0].FlightStart.Format(time.RFC3339), landing.FlightEnd.Format(time.RFC3339)))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return booking, problems.Err()
+	}
+
+	// Every passenger pays for the same flights
+	pricePerPassenger := math.Round((legsPrice(legs)+legsPrice(returnLegs))*100) / 100
+	totalPrice := pricePerPassenger * float64(len(booking.Passengers))
+	// A price sent by the client is what they agreed to pay, so it has to match the one shown in the search
+	if booking.TotalPrice != 0 && fmt.Sprintf("%.2f", booking.TotalPrice) != fmt.Sprintf("%.2f", totalPrice) {
+		problems.Add("totalPrice", fmt.Sprintf("is %.2f, not %.2f", totalPrice, booking.TotalPrice))
+		return booking, problems.Err()
+	}
+
+	booking.FirstName = booking.Passengers[0].FirstName
+	booking.LastName = booking.Passengers[0].LastName
+	booking.PricelistID = pricelist.ID
+	booking.ValidUntil = pricelist.ValidUntil.Format(time.RFC3339)
+	booking.PricePerPassenger = pricePerPassenger
+	booking.TotalPrice = math.Round(totalPrice*100) / 100
+	booking.Legs = legs
+	booking.CompanyNames = legsCompanyNames(legs)
+	booking.StartTime = legs[0].FlightStart.Format(time.RFC3339)
+	booking.TotalDuration = legsDuration(legs)
+	booking.Routes = structs.Routes{From: legs[0].From, Destination: legs[len(legs)-1].To}
+	if booking.Return != nil {
+		booking.Return.Legs = returnLegs
+		booking.Return.CompanyNames = legsCompanyNames(returnLegs)
+		booking.Return.StartTime = returnLegs[0].FlightStart.Format(time.RFC3339)
+		booking.Return.TotalDuration = legsDuration(returnLegs)
+		booking.Return.Price = math.Round(legsPrice(returnLegs)*100) / 100
+		booking.Return.Routes = structs.Routes{From: returnLegs[0].From, Destination: returnLegs[len(returnLegs)-1].To}
+	}
+	return booking, nil
+}
+
+func checkPassengers(passengers []structs.Passenger, namesRequired bool, problems *validation.FieldErrors) {
+	for i, passenger := range passengers {
+		field := fmt.Sprintf("passengers[%d]", i)
+		if namesRequired && strings.TrimSpace(passenger.FirstName) == "" {
+			problems.Add(field+".firstName", "is required")
+		}
+		if namesRequired && strings.TrimSpace(passenger.LastName) == "" {
+			problems.Add(field+".lastName", "is required")
+		}
+		if passenger.DateOfBirth != "" {
+			if _, err := time.Parse("2006-01-02", passenger.DateOfBirth); err != nil {
+				problems.Add(field+".dateOfBirth", "is not a YYYY-MM-DD date")
+			}
+		}
+	}
+}
+
+// Looks up the providers of one direction and checks that they connect
+func bookedLegs(store Store, pricelistID string, providerIDs []string, field string, problems *validation.FieldErrors) ([]structs.BookedLeg, error) {
+	if len(providerIDs) == 0 {
+		problems.Add(field, "at least one provider is required")
+		return nil, nil
+	}
+
+	var legs []structs.BookedLeg
+	found := true
+	for i, providerID := range providerIDs {
+		leg, err := store.BookedLeg(pricelistID, providerID)
+		if errors.Is(err, ErrUnknownProvider) {
+			problems.Add(fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("provider %s is not in pricelist %s", providerID, pricelistID))
+			found = false
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, leg)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	for i := 1; i < len(legs); i++ {
+		previous, next := legs[i-1], legs[i]
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		if next.From != previous.To {
+			problems.Add(itemField, fmt.Sprintf("departs from %s but the previous flight lands on %s", next.From, previous.To))
+		} else if !next.FlightStart.After(previous.FlightEnd) {
+			problems.Add(itemField, fmt.Sprintf("departs at %s before the previous flight lands at %s",
+				next.FlightStart.Format(time.RFC3339), previous.FlightEnd.Format(time.RFC3339)))
+		}
+	}
+	return legs, nil
+}
+
+func legsPrice(legs []structs.BookedLeg) float64 {
+	var price float64
+	for _, leg := range legs {
+		price += leg.Price
+	}
+	return price
+}
+
+func legsCompanyNames(legs []structs.BookedLeg) []string {
+	names := make([]string, len(legs))
+	for i, leg := range legs {
+		names[i] = leg.CompanyName
+	}
+	return names
+}
+
+func legsDuration(legs []structs.BookedLeg) string {
+	return calculations.FormatDuration(legs[len(legs)-1].FlightEnd.Sub(legs[0].FlightStart))
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"space-travel/calculations"
+	"space-travel/structs"
+	"space-travel/validation"
+	"testing"
+	"time"
+)
+
+// The fields a booking request was refused for, or the error itself when it is not a validation error
+func refusedFields(err error) string {
+	var problems validation.FieldErrors
+	if !errors.As(err, &problems) {
+		return fmt.Sprint(err)
+	}
+	var fields []string
+	for _, problem := range problems {
+		fields = append(fields, problem.Field)
+	}
+	return fmt.Sprint(fields)
+}
+
+func TestPrepareRoundTripBooking(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	store := NewMemoryStore()
+	list := jumpsPricelist("trip", now.Add(time.Hour),
+		[2]string{"Earth", "Mars"}, [2]string{"Mars", "Earth"}, [2]string{"Mars", "Earth"}, [2]string{"Venus", "Earth"})
+	if err := store.InsertPricelist(list); err != nil {
+		t.Fatal(err)
+	}
+	provider := func(jump int) string {
+		return list.Legs[jump].Providers[0].ID
+	}
+	request := func(returnIDs ...string) structs.Booking {
+		return structs.Booking{
+			PricelistID: list.ID,
+			ProviderIDs: []string{provider(0)},
+			Passengers:  []structs.Passenger{{FirstName: "Ada", LastName: "Lovelace"}, {FirstName: "Alan", LastName: "Turing"}},
+			Return:      &structs.ReturnTrip{ProviderIDs: returnIDs},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request structs.Booking
+		from    string
+		price   float64
+		refused string
+	}{
+		{name: "return to the origin", request: request(provider(2)), from: "Mars", price: 120},
+		{name: "open jaw", request: request(provider(3)), from: "Venus", price: 130},
+		{name: "return leaving as the outbound lands", request: request(provider(1)), refused: "[return.providerIDs[0]]"},
+		{name: "no return providers", request: request(), refused: "[return.providerIDs]"},
+		{name: "unknown return provider", request: request("trip-missing"), refused: "[return.providerIDs[0]]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			booking, err := PrepareBooking(store, test.request, now)
+			if test.refused != "" {
+				if refusedFields(err) != test.refused {
+					t.Fatalf("refused %s, want %s", refusedFields(err), test.refused)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			trip := booking.Return
+			if trip.Price != test.price || len(trip.Legs) != 1 || trip.Routes.From != test.from || trip.Routes.Destination != "Earth" ||
+				trip.StartTime != trip.Legs[0].FlightStart.Format(time.RFC3339) || fmt.Sprint(trip.CompanyNames) != "[Company]" {
+				t.Fatalf("return is %+v, want %s to Earth for %.2f", trip, test.from, test.price)
+			}
+			// Both passengers pay for both directions
+			if booking.PricePerPassenger != 100+test.price || booking.TotalPrice != 2*(100+test.price) {
+				t.Fatalf("booking costs %.2f per passenger and %.2f in total", booking.PricePerPassenger, booking.TotalPrice)
+			}
+			if booking.Routes.From != "Earth" || booking.Routes.Destination != "Mars" {
+				t.Fatalf("outbound routes are %+v", booking.Routes)
+			}
+
+			stored, err := store.AddBooking(booking, SeatCapacity{Default: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			booked, err := store.BookedSeats(list.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if booked[provider(0)] != 2 || booked[trip.Legs[0].ProviderID] != 2 {
+				t.Fatalf("round trip took %v seats", booked)
+			}
+			if _, err := store.CancelBooking(stored.Reference, now); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPrepareBooking(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	store := NewMemoryStore()
+	// Mars to Jupiter leaves as the first flight lands and again two hours later
+	list := jumpsPricelist("prepare", now.Add(time.Hour),
+		[2]string{"Earth", "Mars"}, [2]string{"Mars", "Jupiter"}, [2]string{"Venus", "Jupiter"}, [2]string{"Mars", "Jupiter"})
+	other := jumpsPricelist("other", now.Add(2*time.Hour), [2]string{"Earth", "Mars"})
+	for _, pricelist := range []structs.Pricelist{list, other} {
+		if err := store.InsertPricelist(pricelist); err != nil {
+			t.Fatal(err)
+		}
+	}
+	provider := func(jump int) string {
+		return list.Legs[jump].Providers[0].ID
+	}
+	lead := []structs.Passenger{{FirstName: "Ada", LastName: "Lovelace"}}
+	request := func(providerIDs ...string) structs.Booking {
+		return structs.Booking{PricelistID: list.ID, ProviderIDs: providerIDs, Passengers: lead}
+	}
+	with := func(booking structs.Booking, change func(*structs.Booking)) structs.Booking {
+		change(&booking)
+		return booking
+	}
+
+	tests := []struct {
+		name          string
+		request       structs.Booking
+		now           time.Time
+		namesOptional bool
+		refused       string
+		err           error
+	}{
+		{name: "connecting flights", request: request(provider(0), provider(3))},
+		// Whatever the client claims about the flights is replaced by the stored providers
+		{name: "client fields are recomputed", request: with(request(provider(0), provider(3)), func(b *structs.Booking) {
+			b.StartTime = now.Format(time.RFC3339)
+			b.TotalDuration = "1 minutes"
+			b.CompanyNames = []string{"Cheap Rockets"}
+			b.ValidUntil = now.Add(time.Hour * 24 * 365).Format(time.RFC3339)
+			b.Routes = structs.Routes{From: "Pluto", Destination: "Jupiter"}
+			b.Legs = []structs.BookedLeg{{ProviderID: provider(0), Price: 1}}
+			b.PricePerPassenger = 1
+		})},
+		{name: "agreed price", request: with(request(provider(0), provider(3)), func(b *structs.Booking) { b.TotalPrice = 230 })},
+		{name: "changed price", request: with(request(provider(0), provider(3)), func(b *structs.Booking) { b.TotalPrice = 229.99 }), refused: "[totalPrice]"},
+		{name: "pricelist expires now", request: request(provider(0)), now: list.ValidUntil, err: ErrPricelistExpired},
+		{name: "pricelist expired", request: request(provider(0)), now: list.ValidUntil.Add(time.Second), err: ErrPricelistExpired},
+		{name: "missing pricelist", request: with(request(provider(0)), func(b *structs.Booking) { b.PricelistID = "" }), refused: "[pricelistID]"},
+		{name: "unknown pricelist", request: with(request(provider(0)), func(b *structs.Booking) { b.PricelistID = "prepare-missing" }), refused: "[pricelistID]"},
+		{name: "no providers", request: request(), refused: "[providerIDs]"},
+		{name: "unknown provider", request: request(provider(0), "prepare-missing"), refused: "[providerIDs[1]]"},
+		{name: "provider of another pricelist", request: request(other.Legs[0].Providers[0].ID), refused: "[providerIDs[0]]"},
+		{name: "from another planet", request: request(provider(0), provider(2)), refused: "[providerIDs[1]]"},
+		{name: "leaving as the previous flight lands", request: request(provider(0), provider(1)), refused: "[providerIDs[1]]"},
+		{name: "flights out of order", request: request(provider(3), provider(0)), refused: "[providerIDs[1]]"},
+		{name: "lead passenger without names", request: structs.Booking{PricelistID: list.ID, ProviderIDs: []string{provider(0)}}, refused: "[firstName lastName]"},
+		{name: "lead passenger with blank names", request: structs.Booking{PricelistID: list.ID, ProviderIDs: []string{provider(0)}, FirstName: " ", LastName: "Lovelace"}, refused: "[firstName]"},
+		{name: "passenger fields", request: with(request(provider(0)), func(b *structs.Booking) {
+			b.Passengers = []structs.Passenger{
+				{FirstName: "Ada", LastName: "Lovelace", DateOfBirth: "1815-12-10"},
+				{FirstName: "Alan", DateOfBirth: "23.06.1912"},
+				{LastName: "Hopper", DateOfBirth: "1906-12-09"},
+			}
+		}), refused: "[passengers[1].lastName passengers[1].dateOfBirth passengers[2].firstName]"},
+		// Every problem of a request is reported at once
+		{name: "passenger and provider fields", request: with(request(provider(0), "prepare-missing"), func(b *structs.Booking) {
+			b.Passengers = []structs.Passenger{{FirstName: "Ada"}}
+		}), refused: "[passengers[0].lastName providerIDs[1]]"},
+		// Holds may leave the names out, but not the rest
+		{name: "hold without names", request: structs.Booking{PricelistID: list.ID, ProviderIDs: []string{provider(0)}}, namesOptional: true},
+		{name: "hold with a bad date of birth", request: with(request(provider(0)), func(b *structs.Booking) {
+			b.Passengers = []structs.Passenger{{DateOfBirth: "tomorrow"}}
+		}), namesOptional: true, refused: "[passengers[0].dateOfBirth]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.now.IsZero() {
+				test.now = now
+			}
+			booking, err := prepareBooking(store, test.request, test.now, !test.namesOptional)
+			if test.err != nil || test.refused != "" {
+				if test.err != nil && !errors.Is(err, test.err) {
+					t.Fatalf("returned %v, want %v", err, test.err)
+				}
+				if test.refused != "" && refusedFields(err) != test.refused {
+					t.Fatalf("refused %s, want %s", refusedFields(err), test.refused)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var legs []structs.Leg
+			for _, id := range test.request.ProviderIDs {
+				for _, leg := range list.Legs {
+					if leg.Providers[0].ID == id {
+						legs = append(legs, leg)
+					}
+				}
+			}
+			first, last := legs[0].Providers[0], legs[len(legs)-1].Providers[0]
+			var price float64
+			var companies []string
+			for i, leg := range legs {
+				price += leg.Providers[0].Price
+				companies = append(companies, leg.Providers[0].Company.Name)
+				got := booking.Legs[i]
+				if got.ProviderID != leg.Providers[0].ID || got.Price != leg.Providers[0].Price || got.From != leg.RouteInfo.From.Name ||
+					got.To != leg.RouteInfo.To.Name || !got.FlightStart.Equal(leg.Providers[0].FlightStart) || !got.FlightEnd.Equal(leg.Providers[0].FlightEnd) {
+					t.Fatalf("leg %d is %+v, want %+v", i, got, leg)
+				}
+			}
+			if len(booking.Legs) != len(legs) || booking.PricePerPassenger != price || booking.TotalPrice != price*float64(len(booking.Passengers)) {
+				t.Fatalf("booking of %d legs costs %.2f per passenger and %.2f in total, want %d legs for %.2f",
+					len(booking.Legs), booking.PricePerPassenger, booking.TotalPrice, len(legs), price)
+			}
+			if booking.StartTime != first.FlightStart.Format(time.RFC3339) || booking.ValidUntil != list.ValidUntil.Format(time.RFC3339) ||
+				booking.TotalDuration != calculations.FormatDuration(last.FlightEnd.Sub(first.FlightStart)) {
+				t.Fatalf("booking starts at %s, takes %s and is valid until %s", booking.StartTime, booking.TotalDuration, booking.ValidUntil)
+			}
+			if fmt.Sprint(booking.CompanyNames) != fmt.Sprint(companies) ||
+				booking.Routes != (structs.Routes{From: legs[0].RouteInfo.From.Name, Destination: legs[len(legs)-1].RouteInfo.To.Name}) {
+				t.Fatalf("booking flies %v from %+v", booking.CompanyNames, booking.Routes)
+			}
+			if len(booking.Passengers) == 0 || booking.FirstName != booking.Passengers[0].FirstName || booking.LastName != booking.Passengers[0].LastName {
+				t.Fatalf("lead passenger is %s %s of %+v", booking.FirstName, booking.LastName, booking.Passengers)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// A pricelist with one provider on each of the given from-to jumps, its IDs prefixed so several fit in one database.
+// Jump i departs i hours after validUntil, flies for an hour and costs 100 + 10*i.
+func jumpsPricelist(prefix string, validUntil time.Time, jumps ...[2]string) structs.Pricelist {
+	list := structs.Pricelist{ID: prefix + "-pricelist", ValidUntil: validUntil.UTC().Truncate(time.Second)}
+	for i, jump := range jumps {
+		start := list.ValidUntil.Add(time.Duration(i) * time.Hour)
+		list.Legs = append(list.Legs, structs.Leg{
+			ID: fmt.Sprintf("%s-leg%d", prefix, i),
+			RouteInfo: structs.RouteInfo{
+				ID:       fmt.Sprintf("%s-route%d", prefix, i),
+				From:     structs.Location{ID: prefix + "-" + jump[0], Name: jump[0]},
+				To:       structs.Location{ID: prefix + "-" + jump[1], Name: jump[1]},
+				Distance: 1000 * (i + 1),
+			},
+			Providers: []structs.Provider{{
+				ID:          fmt.Sprintf("%s-provider%d", prefix, i),
+				Company:     structs.Company{ID: prefix + "-company", Name: "Company"},
+				Price:       float64(100 + 10*i),
+				FlightStart: start,
+				FlightEnd:   start.Add(time.Hour),
+			}},
+		})
+	}
+	return list
+}
+
+func TestGetRouteGraph(t *testing.T) {
+	if _, err := GetRouteGraph(NewMemoryStore()); !errors.Is(err, ErrNoPricelist) {
+		t.Fatalf("graph of an empty store returned %v, want ErrNoPricelist", err)
+	}
+	for _, backend := range testStores {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			now := time.Now()
+			prefix := fmt.Sprintf("%s-%d", backend.name, now.UnixNano())
+			older := jumpsPricelist(prefix+"-older", now.Add(time.Hour), [2]string{"Earth", "Saturn"})
+			latest := jumpsPricelist(prefix+"-latest", now.Add(2*time.Hour),
+				[2]string{"Venus", "Mars"}, [2]string{"Earth", "Venus"}, [2]string{"Earth", "Mars"}, [2]string{"Mars", "Jupiter"},
+				// A second provider on a jump is still one edge
+				[2]string{"Earth", "Mars"})
+			for _, list := range []structs.Pricelist{latest, older} {
+				if err := store.InsertPricelist(list); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			graph, err := GetRouteGraph(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{
+				"Earth":   "[Mars Venus]",
+				"Venus":   "[Mars]",
+				"Mars":    "[Jupiter]",
+				"Jupiter": "[]",
+			}
+			if len(graph) != len(want) {
+				t.Fatalf("graph is %v, want the planets of the latest pricelist only", graph)
+			}
+			for planet, edges := range want {
+				if !graph.HasPlanet(planet) || fmt.Sprint(graph[planet]) != edges {
+					t.Fatalf("graph has %v after %s, want %s", graph[planet], planet, edges)
+				}
+			}
+			if graph.HasPlanet("Saturn") {
+				t.Fatal("graph has a planet of the older pricelist")
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"space-travel/structs"
+	"space-travel/validation"
+	"time"
+)
+
+// Hold statuses
+const (
+	HoldActive    = "held"
+	HoldConfirmed = "confirmed"
+	HoldExpired   = "expired"
+)
+
+var (
+	ErrUnknownHold   = errors.New("Unknown hold")
+	ErrHoldExpired   = errors.New("Hold has expired")
+	ErrHoldConfirmed = errors.New("Hold is already confirmed")
+)
+
+// PrepareHold checks a hold request like PrepareBooking does, except that passenger names can
+// be left out until the hold is confirmed. The hold lasts for the given duration.
+func PrepareHold(store Store, booking structs.Booking, now time.Time, duration time.Duration) (structs.Hold, error) {
+	booking, err := prepareBooking(store, booking, now, false)
+	if err != nil {
+		return structs.Hold{}, err
+	}
+	return structs.Hold{
+		Status:    HoldActive,
+		CreatedAt: now.UTC(),
+		ExpiresAt: now.Add(duration).UTC(),
+		Booking:   booking,
+	}, nil
+}
+
+// CompleteHold turns an active hold into the booking it was made for, at the quoted price even
+// when the pricelist has expired since. Passengers given here replace the ones of the hold but
+// have to be as many, since that is how many seats are held.
+func CompleteHold(hold structs.Hold, passengers []structs.Passenger, now time.Time) (structs.Booking, error) {
+	if err := checkHoldActive(hold, now); err != nil {
+		return structs.Booking{}, err
+	}
+
+	booking := hold.Booking
+	var problems validation.FieldErrors
+	if len(passengers) > 0 {
+		if len(passengers) != len(booking.Passengers) {
+			problems.Add("passengers", fmt.Sprintf("the hold is for %d passengers, not %d", len(booking.Passengers), len(passengers)))
+			return structs.Booking{}, problems.Err()
+		}
+		booking.Passengers = passengers
+	}
+	checkPassengers(booking.Passengers, true, &problems)
+	if len(problems) > 0 {
+		return structs.Booking{}, problems.Err()
+	}
+	booking.FirstName = booking.Passengers[0].FirstName
+	booking.LastName = booking.Passengers[0].LastName
+	return booking, nil
+}
+
+func checkHoldActive(hold structs.Hold, now time.Time) error {
+	switch {
+	case hold.Status == HoldConfirmed:
+		return ErrHoldConfirmed
+	case hold.Status == HoldExpired || !hold.ExpiresAt.After(now):
+		return ErrHoldExpired
+	}
+	return nil
+}
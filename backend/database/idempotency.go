@@ -0,0 +1,38 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// IdempotentRequest is a request sent with an Idempotency-Key header and the response it got
+type IdempotentRequest struct {
+	Key string
+	// Hash of the request, so a key reused for a different request can be told apart
+	RequestHash string
+	CreatedAt   time.Time
+	// Random value of the claim, so that after a claim is taken over the request that lost it
+	// cannot complete or release the new one, even when it was sent with the same body
+	Token string
+	// Zero while the request is still being handled
+	StatusCode  int
+	ContentType string
+	Response    []byte
+	// Booking or hold created by the request
+	Reference string
+}
+
+// Returns a random token for a new claim
+func newClaimToken() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// InProgress reports whether the request has not been answered yet
+func (r IdempotentRequest) InProgress() bool {
+	return r.StatusCode == 0
+}
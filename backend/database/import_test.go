@@ -0,0 +1,155 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// A pricelist with the given number of legs and providers per leg, its IDs prefixed so several fit in one database
+func generatePricelist(prefix string, legs int, perLeg int, validUntil time.Time) structs.Pricelist {
+	rng := rand.New(rand.NewSource(int64(legs*perLeg) + int64(len(prefix))))
+	id := func(kind string, n ...int) string {
+		return fmt.Sprint(prefix, "-", kind, n)
+	}
+	companies := make([]structs.Company, 10)
+	for i := range companies {
+		companies[i] = structs.Company{ID: id("company", i), Name: fmt.Sprintf("Company %d", i)}
+	}
+
+	list := structs.Pricelist{ID: id("pricelist"), ValidUntil: validUntil.UTC().Truncate(time.Second)}
+	for l := 0; l < legs; l++ {
+		leg := structs.Leg{
+			ID: id("leg", l),
+			RouteInfo: structs.RouteInfo{
+				ID:       id("route", l),
+				From:     structs.Location{ID: id("planet", l), Name: fmt.Sprintf("Planet %d", l)},
+				To:       structs.Location{ID: id("planet", l+1), Name: fmt.Sprintf("Planet %d", l+1)},
+				Distance: 1000000 + rng.Intn(1000000000),
+			},
+		}
+		for p := 0; p < perLeg; p++ {
+			start := list.ValidUntil.Add(time.Duration(rng.Intn(30*24*60)) * time.Minute)
+			leg.Providers = append(leg.Providers, structs.Provider{
+				ID:          id("provider", l, p),
+				Company:     companies[rng.Intn(len(companies))],
+				Price:       float64(100 + rng.Intn(900)),
+				FlightStart: start,
+				FlightEnd:   start.Add(time.Duration(60+rng.Intn(48*60)) * time.Minute),
+			})
+		}
+		list.Legs = append(list.Legs, leg)
+	}
+	return list
+}
+
+// The row by row import InsertPricelist replaced, where every statement commits on its own
+func referenceInsertPricelist(s *SQLStore, pricelist structs.Pricelist) error {
+	insertOnce := func(table string, id string, query string, args ...interface{}) error {
+		var count int
+		if err := s.queryRow("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		_, err := s.exec(query, args...)
+		return err
+	}
+
+	for _, leg := range pricelist.Legs {
+		for _, location := range []structs.Location{leg.RouteInfo.From, leg.RouteInfo.To} {
+			if err := insertOnce("locations", location.ID, "INSERT INTO locations (id, name, legID) VALUES (?, ?, ?)", location.ID, location.Name, leg.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, leg := range pricelist.Legs {
+		for _, provider := range leg.Providers {
+			if err := insertOnce("companies", provider.Company.ID, "INSERT INTO companies (id, name, pricelistID) VALUES (?, ?, ?)", provider.Company.ID, provider.Company.Name, pricelist.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := s.exec("INSERT INTO routeInfos (id, FromID, ToID, distance, LegID) VALUES (?, ?, ?, ?, ?)",
+			leg.RouteInfo.ID, leg.RouteInfo.From.ID, leg.RouteInfo.To.ID, leg.RouteInfo.Distance, leg.ID); err != nil {
+			return err
+		}
+		if _, err := s.exec("INSERT INTO legs (id, routeInfoId, PriceListID) VALUES (?, ?, ?)", leg.ID, leg.RouteInfo.ID, pricelist.ID); err != nil {
+			return err
+		}
+	}
+	for _, leg := range pricelist.Legs {
+		for _, provider := range leg.Providers {
+			if _, err := s.exec("INSERT INTO providers (id, companyID, price, flightStart, flightEnd, legID) VALUES (?, ?, ?, ?, ?, ?)",
+				provider.ID, provider.Company.ID, provider.Price, provider.FlightStart, provider.FlightEnd, leg.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return insertOnce("pricelists", pricelist.ID, "INSERT INTO pricelists (id, validUntil) VALUES (?, ?)", pricelist.ID, pricelist.ValidUntil)
+}
+
+func newTempSQLiteStore(tb testing.TB) *SQLStore {
+	store, err := NewSQLiteStore(filepath.Join(tb.TempDir(), "pricelists.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestInsertPricelistMatchesReference(t *testing.T) {
+	list := generatePricelist("list", 4, 20, time.Now().Add(time.Hour))
+	imported := map[string]func(*SQLStore, structs.Pricelist) error{
+		"current":   (*SQLStore).InsertPricelist,
+		"reference": referenceInsertPricelist,
+	}
+	counts := map[string]string{}
+	for name, insert := range imported {
+		store := newTempSQLiteStore(t)
+		if err := insert(store, list); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var summary string
+		for _, table := range []string{"pricelists", "legs", "routeInfos", "locations", "companies", "providers"} {
+			var count int
+			if err := store.queryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			summary += fmt.Sprintf("%s=%d ", table, count)
+		}
+		counts[name] = summary
+	}
+	if counts["current"] != counts["reference"] {
+		t.Fatalf("current import stored %s, reference stored %s", counts["current"], counts["reference"])
+	}
+}
+
+// Each iteration imports into a fresh database, which is created outside the timer
+func BenchmarkInsertPricelist(b *testing.B) {
+	for _, perLeg := range []int{100, 1000} {
+		list := generatePricelist("list", 14, perLeg, time.Now().Add(time.Hour))
+		imported := []struct {
+			name   string
+			insert func(*SQLStore, structs.Pricelist) error
+		}{
+			{"transaction", (*SQLStore).InsertPricelist},
+			{"autocommit", referenceInsertPricelist},
+		}
+		for _, imp := range imported {
+			b.Run(fmt.Sprintf("%s/providers=%d", imp.name, 14*perLeg), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					store := newTempSQLiteStore(b)
+					b.StartTimer()
+					if err := imp.insert(store, list); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"space-travel/calculations"
+	"space-travel/structs"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in process memory, for tests and throwaway runs
+type MemoryStore struct {
+	mu         sync.Mutex
+	pricelists map[string]structs.Pricelist
+	rejected   []rejectedPricelist
+	// Cached responses are kept as JSON so callers never share them
+	cache         map[cacheKey][]byte
+	bookings      []storedBooking
+	nextBookingID int64
+	holds         []structs.Hold
+	idempotency   map[string]IdempotentRequest
+	// Seats taken per provider flight
+	bookedSeats map[string]int
+}
+
+type rejectedPricelist struct {
+	pricelistID string
+	rejectedAt  time.Time
+	reason      string
+	payload     []byte
+}
+
+type cacheKey struct {
+	pricelistID string
+	from        string
+	destination string
+}
+
+type storedBooking struct {
+	id       int64
+	snapshot []byte
+}
+
+func (b storedBooking) decode() (structs.Booking, error) {
+	var booking structs.Booking
+	err := json.Unmarshal(b.snapshot, &booking)
+	return booking, err
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pricelists:    map[string]structs.Pricelist{},
+		cache:         map[cacheKey][]byte{},
+		nextBookingID: 1,
+		bookedSeats:   map[string]int{},
+		idempotency:   map[string]IdempotentRequest{},
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) InsertPricelist(pricelist structs.Pricelist) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pricelists[pricelist.ID]; ok {
+		return nil
+	}
+
+	// Keep a copy so later changes by the caller do not leak into the store
+	body, err := json.Marshal(pricelist)
+	if err != nil {
+		return err
+	}
+	var stored structs.Pricelist
+	if err := json.Unmarshal(body, &stored); err != nil {
+		return err
+	}
+	m.pricelists[pricelist.ID] = stored
+	return nil
+}
+
+func (m *MemoryStore) Pricelist(pricelistID string) (PricelistSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pricelist, ok := m.pricelists[pricelistID]
+	if !ok {
+		return PricelistSummary{}, ErrNoPricelist
+	}
+	return PricelistSummary{ID: pricelist.ID, ValidUntil: pricelist.ValidUntil}, nil
+}
+
+func (m *MemoryStore) Pricelists() ([]PricelistSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pricelists := make([]PricelistSummary, 0, len(m.pricelists))
+	for id, pricelist := range m.pricelists {
+		pricelists = append(pricelists, PricelistSummary{ID: id, ValidUntil: pricelist.ValidUntil})
+	}
+	sort.Slice(pricelists, func(i, j int) bool {
+		return pricelists[i].ValidUntil.After(pricelists[j].ValidUntil)
+	})
+	return pricelists, nil
+}
+
+func (m *MemoryStore) DeletePricelist(pricelistID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, leg := range m.pricelists[pricelistID].Legs {
+		for _, provider := range leg.Providers {
+			delete(m.bookedSeats, provider.ID)
+		}
+	}
+	delete(m.pricelists, pricelistID)
+	for key := range m.cache {
+		if key.pricelistID == pricelistID {
+			delete(m.cache, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) LatestPricelist() (PricelistSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest PricelistSummary
+	for id, pricelist := range m.pricelists {
+		if latest.ID == "" || pricelist.ValidUntil.After(latest.ValidUntil) {
+			latest = PricelistSummary{ID: id, ValidUntil: pricelist.ValidUntil}
+		}
+	}
+	if latest.ID == "" {
+		return PricelistSummary{}, ErrNoPricelist
+	}
+	return latest, nil
+}
+
+func (m *MemoryStore) RecordRejectedPricelist(pricelist structs.Pricelist, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rejected := range m.rejected {
+		if rejected.pricelistID == pricelist.ID && rejected.reason == reason {
+			return nil
+		}
+	}
+	payload, err := json.Marshal(pricelist)
+	if err != nil {
+		return err
+	}
+	m.rejected = append(m.rejected, rejectedPricelist{
+		pricelistID: pricelist.ID,
+		rejectedAt:  time.Now().UTC(),
+		reason:      reason,
+		payload:     payload,
+	})
+	return nil
+}
+
+func (m *MemoryStore) RouteGraph(pricelistID string) (calculations.Graph, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type edge struct{ from, to string }
+	seen := map[edge]bool{}
+	var edges []edge
+	for _, leg := range m.pricelists[pricelistID].Legs {
+		e := edge{leg.RouteInfo.From.Name, leg.RouteInfo.To.Name}
+		if !seen[e] {
+			seen[e] = true
+			edges = append(edges, e)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+
+	graph := calculations.Graph{}
+	for _, e := range edges {
+		graph[e.from] = append(graph[e.from], e.to)
+		// Planets that only appear as a destination are still valid endpoints
+		if _, ok := graph[e.to]; !ok {
+			graph[e.to] = nil
+		}
+	}
+	return graph, nil
+}
+
+func (m *MemoryStore) LegProviders(pricelistID string, from string, destination string) ([]structs.SimplifiedProvider, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var providers []structs.SimplifiedProvider
+	distance := 0
+	for _, leg := range m.pricelists[pricelistID].Legs {
+		if leg.RouteInfo.From.Name != from || leg.RouteInfo.To.Name != destination {
+			continue
+		}
+		for _, provider := range leg.Providers {
+			if distance == 0 {
+				distance = leg.RouteInfo.Distance
+			}
+			providers = append(providers, structs.SimplifiedProvider{
+				ID:          provider.ID,
+				CompanyName: provider.Company.Name,
+				CompanyID:   provider.Company.ID,
+				Price:       provider.Price,
+				FlightStart: provider.FlightStart,
+				FlightEnd:   provider.FlightEnd,
+			})
+		}
+	}
+	sort.SliceStable(providers, func(i, j int) bool {
+		return providers[i].FlightStart.Before(providers[j].FlightStart)
+	})
+	return providers, distance, nil
+}
+
+func (m *MemoryStore) BookedLeg(pricelistID string, providerID string) (structs.BookedLeg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, leg := range m.pricelists[pricelistID].Legs {
+		for _, provider := range leg.Providers {
+			if provider.ID == providerID {
+				return structs.BookedLeg{
+					ProviderID:  provider.ID,
+					CompanyName: provider.Company.Name,
+					From:        leg.RouteInfo.From.Name,
+					To:          leg.RouteInfo.To.Name,
+					Price:       provider.Price,
+					FlightStart: provider.FlightStart,
+					FlightEnd:   provider.FlightEnd,
+				}, nil
+			}
+		}
+	}
+	return structs.BookedLeg{}, ErrUnknownProvider
+}
+
+func (m *MemoryStore) ClaimIdempotencyKey(key string, requestHash string, now time.Time, retention time.Duration, lease time.Duration) (IdempotentRequest, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for storedKey, request := range m.idempotency {
+		if request.CreatedAt.Before(now.Add(-retention)) ||
+			(lease > 0 && request.InProgress() && request.CreatedAt.Before(now.Add(-lease))) {
+			delete(m.idempotency, storedKey)
+		}
+	}
+	if request, ok := m.idempotency[key]; ok {
+		request.Response = append([]byte(nil), request.Response...)
+		// The token belongs to the request holding the claim
+		request.Token = ""
+		return request, false, nil
+	}
+	token, err := newClaimToken()
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	request := IdempotentRequest{Key: key, RequestHash: requestHash, CreatedAt: now.UTC(), Token: token}
+	m.idempotency[key] = request
+	return request, true, nil
+}
+
+func (m *MemoryStore) CompleteIdempotencyKey(request IdempotentRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.idempotency[request.Key]
+	if !ok || !stored.InProgress() || stored.Token != request.Token {
+		return nil
+	}
+	stored.StatusCode = request.StatusCode
+	stored.ContentType = request.ContentType
+	stored.Response = append([]byte(nil), request.Response...)
+	stored.Reference = request.Reference
+	m.idempotency[request.Key] = stored
+	return nil
+}
+
+func (m *MemoryStore) ReleaseIdempotencyKey(request IdempotentRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.idempotency[request.Key]; ok && stored.InProgress() && stored.Token == request.Token {
+		delete(m.idempotency, request.Key)
+	}
+	return nil
+}
+
+func (m *MemoryStore) BookedSeats(pricelistID string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	booked := map[string]int{}
+	for _, leg := range m.pricelists[pricelistID].Legs {
+		for _, provider := range leg.Providers {
+			if seats, ok := m.bookedSeats[provider.ID]; ok {
+				booked[provider.ID] = seats
+			}
+		}
+	}
+	return booked, nil
+}
+
+func (m *MemoryStore) CachedRoutes(pricelistID string, from string, destination string) (structs.GetResponse, bool, error) {
+	m.mu.Lock()
+	body, ok := m.cache[cacheKey{pricelistID, from, destination}]
+	m.mu.Unlock()
+	if !ok {
+		return structs.GetResponse{}, false, nil
+	}
+
+	var cachedData structs.GetResponse
+	if err := json.Unmarshal(body, &cachedData); err != nil {
+		return structs.GetResponse{}, false, err
+	}
+	return cachedData, true, nil
+}
+
+func (m *MemoryStore) CacheRoutes(pricelistID string, from string, destination string, response structs.GetResponse) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := cacheKey{pricelistID, from, destination}
+	// Like the SQL stores, the first cached response for a search wins
+	if _, ok := m.cache[key]; !ok {
+		m.cache[key] = body
+	}
+	return nil
+}
+
+func (m *MemoryStore) CleanCache(pricelistID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.cache {
+		if key.pricelistID != pricelistID {
+			delete(m.cache, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddBooking(booking structs.Booking, capacity SeatCapacity) (structs.Booking, error) {
+	booking, err := newConfirmedBooking(booking, time.Now())
+	if err != nil {
+		return structs.Booking{}, err
+	}
+
+	// Like the SQL stores, keep a snapshot the caller cannot change afterwards
+	snapshot, err := json.Marshal(booking)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.takeSeats(booking, capacity); err != nil {
+		return structs.Booking{}, err
+	}
+	m.bookings = append(m.bookings, storedBooking{id: m.nextBookingID, snapshot: snapshot})
+	m.nextBookingID++
+	return booking, nil
+}
+
+// Takes the seats of a booking, checking every flight first so a sold out one changes nothing
+func (m *MemoryStore) takeSeats(booking structs.Booking, capacity SeatCapacity) error {
+	seats := bookingSeats(booking)
+	taken := map[string]int{}
+	for _, leg := range bookingLegs(booking) {
+		taken[leg.ProviderID] += seats
+		if m.bookedSeats[leg.ProviderID]+taken[leg.ProviderID] > capacity.Seats(leg.CompanyName) {
+			return fmt.Errorf("%w on the %s flight from %s to %s", ErrSoldOut, leg.CompanyName, leg.From, leg.To)
+		}
+	}
+	for providerID, count := range taken {
+		m.bookedSeats[providerID] += count
+	}
+	return nil
+}
+
+// Gives back the seats of a booking, skipping flights whose pricelist was deleted since
+func (m *MemoryStore) releaseSeats(booking structs.Booking) {
+	for _, leg := range bookingLegs(booking) {
+		seats, ok := m.bookedSeats[leg.ProviderID]
+		if !ok {
+			continue
+		}
+		if seats -= bookingSeats(booking); seats < 0 {
+			seats = 0
+		}
+		m.bookedSeats[leg.ProviderID] = seats
+	}
+}
+
+func (m *MemoryStore) AddHold(hold structs.Hold, capacity SeatCapacity) (structs.Hold, error) {
+	reference, err := newBookingReference()
+	if err != nil {
+		return structs.Hold{}, err
+	}
+	hold.Reference = reference
+	hold.Status = HoldActive
+	stored, err := copyHold(hold)
+	if err != nil {
+		return structs.Hold{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.takeSeats(hold.Booking, capacity); err != nil {
+		return structs.Hold{}, err
+	}
+	m.holds = append(m.holds, stored)
+	return hold, nil
+}
+
+// Holds are kept as copies so callers never share them
+func copyHold(hold structs.Hold) (structs.Hold, error) {
+	body, err := json.Marshal(hold)
+	if err != nil {
+		return structs.Hold{}, err
+	}
+	var copied structs.Hold
+	err = json.Unmarshal(body, &copied)
+	return copied, err
+}
+
+func (m *MemoryStore) Hold(reference string) (structs.Hold, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hold := range m.holds {
+		if hold.Reference == reference {
+			return copyHold(hold)
+		}
+	}
+	return structs.Hold{}, ErrUnknownHold
+}
+
+func (m *MemoryStore) ConfirmHold(reference string, booking structs.Booking, now time.Time) (structs.Booking, error) {
+	booking, err := newConfirmedBooking(booking, now)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+	snapshot, err := json.Marshal(booking)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hold := range m.holds {
+		if hold.Reference != reference {
+			continue
+		}
+		if err := checkHoldActive(hold, now); err != nil {
+			return structs.Booking{}, err
+		}
+		// The held seats now belong to the booking, so they are not taken again
+		m.holds[i].Status = HoldConfirmed
+		m.holds[i].BookingReference = booking.Reference
+		m.bookings = append(m.bookings, storedBooking{id: m.nextBookingID, snapshot: snapshot})
+		m.nextBookingID++
+		return booking, nil
+	}
+	return structs.Booking{}, ErrUnknownHold
+}
+
+func (m *MemoryStore) ReleaseExpiredHolds(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	released := 0
+	for i, hold := range m.holds {
+		if hold.Status != HoldActive || hold.ExpiresAt.After(now) {
+			continue
+		}
+		m.holds[i].Status = HoldExpired
+		m.releaseSeats(hold.Booking)
+		released++
+	}
+	return released, nil
+}
+
+func (m *MemoryStore) Booking(reference string) (structs.Booking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.bookings {
+		booking, err := stored.decode()
+		if err != nil {
+			return structs.Booking{}, err
+		}
+		if booking.Reference == reference {
+			return booking, nil
+		}
+	}
+	return structs.Booking{}, ErrUnknownBooking
+}
+
+func (m *MemoryStore) Bookings(filter BookingFilter) ([]structs.Booking, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bookings := []structs.Booking{}
+	total := 0
+	for i := len(m.bookings) - 1; i >= 0; i-- {
+		booking, err := m.bookings[i].decode()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !filter.matches(booking) {
+			continue
+		}
+		if total >= filter.Offset && (filter.Limit <= 0 || len(bookings) < filter.Limit) {
+			bookings = append(bookings, booking)
+		}
+		total++
+	}
+	return bookings, total, nil
+}
+
+func (m *MemoryStore) CancelBooking(reference string, at time.Time) (structs.Booking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, stored := range m.bookings {
+		booking, err := stored.decode()
+		if err != nil {
+			return structs.Booking{}, err
+		}
+		if booking.Reference != reference {
+			continue
+		}
+		if booking.Status == BookingCancelled {
+			return booking, ErrAlreadyCancelled
+		}
+		cancelledAt := at.UTC()
+		booking.Status = BookingCancelled
+		booking.CancelledAt = &cancelledAt
+		snapshot, err := json.Marshal(booking)
+		if err != nil {
+			return structs.Booking{}, err
+		}
+		m.bookings[i].snapshot = snapshot
+		m.releaseSeats(booking)
+		return booking, nil
+	}
+	return structs.Booking{}, ErrUnknownBooking
+}
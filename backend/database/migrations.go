@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Up migrations named <version>_<description>.sql, one directory per dialect, applied in version order
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// Brings the schema up to date, applying every migration newer than the
+// version recorded in schema_version in its own transaction
+func migrate(db *sql.DB, d dialect) error {
+	migrations, err := loadMigrations(d.migrations)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			Version   INTEGER PRIMARY KEY,
+			AppliedAt TIMESTAMP NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		legacy, err := legacySQLiteDatabase(db, d)
+		if err != nil {
+			return err
+		}
+		if legacy {
+			log.Printf("Found a database without schema version, treating it as version 1")
+			if _, err := db.Exec(d.rebind("INSERT INTO schema_version (Version, AppliedAt) VALUES (1, ?)"), time.Now()); err != nil {
+				return err
+			}
+			current = 1
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Applying migration %s", m.name)
+		if err := applyMigration(db, d, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func loadMigrations(dir string) ([]migration, error) {
+	dir = path.Join("migrations", dir)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s does not start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, name, version)
+		}
+		seen[version] = name
+
+		body, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+func applyMigration(db *sql.DB, d dialect, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(d.rebind("INSERT INTO schema_version (Version, AppliedAt) VALUES (?, ?)"), m.version, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(Version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// SQLite databases created from tables.sql before migrations existed have tables but no schema version
+func legacySQLiteDatabase(db *sql.DB, d dialect) (bool, error) {
+	if d != sqliteDialect {
+		return false, nil
+	}
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'Pricelists'").Scan(&count)
+	return count > 0, err
+}
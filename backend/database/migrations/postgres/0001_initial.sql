@@ -0,0 +1,92 @@
+-- PostgreSQL enforces foreign keys, and a pricelist is imported in one transaction in an
+-- order that does not follow them, so every foreign key is only checked on commit.
+
+-- Pricelist table
+CREATE TABLE IF NOT EXISTS Pricelists (
+    ID         VARCHAR(36) PRIMARY KEY,
+    ValidUntil TIMESTAMPTZ
+);
+
+-- Company table
+CREATE TABLE IF NOT EXISTS Companies (
+    ID          VARCHAR(36) PRIMARY KEY,
+    Name        VARCHAR(255) NOT NULL,
+    PriceListID VARCHAR(36) REFERENCES Pricelists(ID) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- Leg table
+CREATE TABLE IF NOT EXISTS Legs (
+    ID          VARCHAR(36) PRIMARY KEY,
+    RouteInfoID VARCHAR(36),
+    PriceListID VARCHAR(36) REFERENCES Pricelists(ID) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- Location table
+CREATE TABLE IF NOT EXISTS Locations (
+    ID    VARCHAR(36) PRIMARY KEY,
+    Name  VARCHAR(255) NOT NULL,
+    LegID VARCHAR(36) REFERENCES Legs(ID) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- RouteInfo table
+CREATE TABLE IF NOT EXISTS RouteInfos (
+    ID       VARCHAR(36) PRIMARY KEY,
+    FromID   VARCHAR(36) REFERENCES Locations(ID) DEFERRABLE INITIALLY DEFERRED,
+    ToID     VARCHAR(36) REFERENCES Locations(ID) DEFERRABLE INITIALLY DEFERRED,
+    Distance BIGINT,
+    LegID    VARCHAR(36) REFERENCES Legs(ID) DEFERRABLE INITIALLY DEFERRED
+);
+
+ALTER TABLE Legs ADD FOREIGN KEY (RouteInfoID) REFERENCES RouteInfos(ID) DEFERRABLE INITIALLY DEFERRED;
+
+-- Provider table
+CREATE TABLE IF NOT EXISTS Providers (
+    ID          VARCHAR(36) PRIMARY KEY,
+    CompanyID   VARCHAR(36) REFERENCES Companies(ID) DEFERRABLE INITIALLY DEFERRED,
+    Price       DOUBLE PRECISION,
+    FlightStart TIMESTAMPTZ,
+    FlightEnd   TIMESTAMPTZ,
+    LegID       VARCHAR(36) REFERENCES Legs(ID) DEFERRABLE INITIALLY DEFERRED
+);
+
+-- CachedRoutes table
+CREATE TABLE IF NOT EXISTS CachedRoutes (
+    ID           VARCHAR(36) PRIMARY KEY,
+    PricelistID  VARCHAR(36) REFERENCES Pricelists(ID) DEFERRABLE INITIALLY DEFERRED,
+    FromLocation VARCHAR(255) NOT NULL,
+    ToLocation   VARCHAR(255) NOT NULL,
+    Routes       TEXT
+);
+
+--Bookings table
+CREATE TABLE IF NOT EXISTS Bookings (
+    ID              BIGSERIAL PRIMARY KEY,
+    CompanyNames    TEXT NOT NULL,
+    StartTime       TEXT NOT NULL,
+    FirstName       TEXT NOT NULL,
+    LastName        TEXT NOT NULL,
+    TotalPrice      DOUBLE PRECISION NOT NULL,
+    TotalDuration   TEXT NOT NULL,
+    PricelistID     VARCHAR(36) NOT NULL,
+    FromCity        TEXT NOT NULL,
+    DestinationCity TEXT NOT NULL
+);
+
+--BookingReturns table, the return direction of round trip bookings
+CREATE TABLE IF NOT EXISTS BookingReturns (
+    BookingID       BIGINT PRIMARY KEY REFERENCES Bookings(ID) ON DELETE CASCADE,
+    CompanyNames    TEXT NOT NULL,
+    StartTime       TEXT NOT NULL,
+    TotalDuration   TEXT NOT NULL,
+    FromCity        TEXT NOT NULL,
+    DestinationCity TEXT NOT NULL
+);
+
+--RejectedPricelists table, pricelists from upstream that failed validation
+CREATE TABLE IF NOT EXISTS RejectedPricelists (
+    ID          BIGSERIAL PRIMARY KEY,
+    PricelistID VARCHAR(36),
+    RejectedAt  TIMESTAMPTZ NOT NULL,
+    Reason      TEXT NOT NULL,
+    Payload     TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Bookings outlive the pricelist they were made against, so they keep a snapshot of what was bought
+ALTER TABLE Bookings ADD COLUMN BookedAt TIMESTAMPTZ;
+ALTER TABLE Bookings ADD COLUMN Snapshot TEXT;
@@ -0,0 +1,2 @@
+-- Cached responses from before provider IDs were part of the results cannot be booked
+DELETE FROM CachedRoutes;
@@ -0,0 +1,9 @@
+ALTER TABLE Bookings ADD COLUMN Reference VARCHAR(16);
+ALTER TABLE Bookings ADD COLUMN Status TEXT NOT NULL DEFAULT 'confirmed';
+ALTER TABLE Bookings ADD COLUMN CancelledAt TIMESTAMPTZ;
+
+-- Older bookings get a reference derived from their ID. Generated references never
+-- contain 0 or 1, so the two kinds cannot collide.
+UPDATE Bookings SET Reference = 'B' || lpad(ID::text, 7, '0') WHERE Reference IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS BookingsReference ON Bookings(Reference);
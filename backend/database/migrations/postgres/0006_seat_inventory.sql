@@ -0,0 +1,14 @@
+--SeatInventory table, seats taken on each provider flight by confirmed bookings
+CREATE TABLE IF NOT EXISTS SeatInventory (
+    ProviderID VARCHAR(36) PRIMARY KEY,
+    Booked     INTEGER NOT NULL
+);
+
+--BookingSeats table, the seats a booking holds so cancelling it can give them back
+CREATE TABLE IF NOT EXISTS BookingSeats (
+    BookingID  BIGINT NOT NULL REFERENCES Bookings(ID) ON DELETE CASCADE,
+    ProviderID VARCHAR(36) NOT NULL,
+    Seats      INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS BookingSeatsBookingID ON BookingSeats(BookingID);
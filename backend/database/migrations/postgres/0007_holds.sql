@@ -0,0 +1,21 @@
+--Holds table, itineraries whose seats and price are kept for a while before they are booked
+CREATE TABLE IF NOT EXISTS Holds (
+    ID        BIGSERIAL PRIMARY KEY,
+    Reference VARCHAR(16) NOT NULL UNIQUE,
+    Status    TEXT NOT NULL,
+    CreatedAt TIMESTAMPTZ NOT NULL,
+    ExpiresAt TIMESTAMPTZ NOT NULL,
+    Snapshot  TEXT NOT NULL,
+    BookingID BIGINT REFERENCES Bookings(ID)
+);
+
+CREATE INDEX IF NOT EXISTS HoldsStatus ON Holds(Status);
+
+--HoldSeats table, the seats a hold takes until it is confirmed or expires
+CREATE TABLE IF NOT EXISTS HoldSeats (
+    HoldID     BIGINT NOT NULL REFERENCES Holds(ID) ON DELETE CASCADE,
+    ProviderID VARCHAR(36) NOT NULL,
+    Seats      INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS HoldSeatsHoldID ON HoldSeats(HoldID);
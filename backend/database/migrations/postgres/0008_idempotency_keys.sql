@@ -0,0 +1,12 @@
+--IdempotencyKeys table, requests sent with an Idempotency-Key header and what they were answered
+CREATE TABLE IF NOT EXISTS IdempotencyKeys (
+    IdempotencyKey VARCHAR(255) PRIMARY KEY,
+    RequestHash    VARCHAR(64) NOT NULL,
+    CreatedAt      TIMESTAMPTZ NOT NULL,
+    StatusCode     INTEGER,
+    ContentType    TEXT,
+    Response       TEXT,
+    Reference      VARCHAR(16)
+);
+
+CREATE INDEX IF NOT EXISTS IdempotencyKeysCreatedAt ON IdempotencyKeys(CreatedAt);
@@ -0,0 +1,18 @@
+--BookingReturns table, the return direction of round trip bookings
+CREATE TABLE IF NOT EXISTS BookingReturns (
+    BookingID INTEGER PRIMARY KEY REFERENCES Bookings(ID),
+    CompanyNames TEXT NOT NULL,
+    StartTime TEXT NOT NULL,
+    TotalDuration TEXT NOT NULL,
+    FromCity TEXT NOT NULL,
+    DestinationCity TEXT NOT NULL
+);
+
+--RejectedPricelists table, pricelists from upstream that failed validation
+CREATE TABLE IF NOT EXISTS RejectedPricelists (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    PricelistID VARCHAR(36),
+    RejectedAt TIMESTAMP NOT NULL,
+    Reason TEXT NOT NULL,
+    Payload TEXT NOT NULL
+);
@@ -0,0 +1,21 @@
+-- Pricelist IDs are UUIDs, so Bookings.PricelistID has to be text.
+-- SQLite cannot change a column type, so the table is rebuilt.
+CREATE TABLE Bookings_new (
+    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    CompanyNames TEXT NOT NULL,
+    StartTime TEXT NOT NULL,
+    FirstName TEXT NOT NULL,
+    LastName TEXT NOT NULL,
+    TotalPrice REAL NOT NULL,
+    TotalDuration TEXT NOT NULL,
+    PricelistID VARCHAR(36) NOT NULL,
+    FromCity TEXT NOT NULL,
+    DestinationCity TEXT NOT NULL
+);
+
+INSERT INTO Bookings_new (ID, CompanyNames, StartTime, FirstName, LastName, TotalPrice, TotalDuration, PricelistID, FromCity, DestinationCity)
+SELECT ID, CompanyNames, StartTime, FirstName, LastName, TotalPrice, TotalDuration, CAST(PricelistID AS TEXT), FromCity, DestinationCity
+FROM Bookings;
+
+DROP TABLE Bookings;
+ALTER TABLE Bookings_new RENAME TO Bookings;
@@ -0,0 +1,17 @@
+-- The composite key (FromID, ToID) -> Locations(ID, ID) does not match any unique key
+-- of Locations, so SQLite rejects every insert once foreign keys are enforced.
+-- Both columns get a foreign key of their own instead.
+CREATE TABLE RouteInfos_new (
+    ID       VARCHAR(36) PRIMARY KEY,
+    FromID   VARCHAR(36) REFERENCES Locations(ID),
+    ToID     VARCHAR(36) REFERENCES Locations(ID),
+    Distance INT,
+    LegID    VARCHAR(36) REFERENCES Legs(ID)
+);
+
+INSERT INTO RouteInfos_new (ID, FromID, ToID, Distance, LegID)
+SELECT ID, FromID, ToID, Distance, LegID
+FROM RouteInfos;
+
+DROP TABLE RouteInfos;
+ALTER TABLE RouteInfos_new RENAME TO RouteInfos;
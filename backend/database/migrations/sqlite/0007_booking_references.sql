@@ -0,0 +1,9 @@
+ALTER TABLE Bookings ADD COLUMN Reference VARCHAR(16);
+ALTER TABLE Bookings ADD COLUMN Status TEXT NOT NULL DEFAULT 'confirmed';
+ALTER TABLE Bookings ADD COLUMN CancelledAt TIMESTAMP;
+
+-- Older bookings get a reference derived from their ID. Generated references never
+-- contain 0 or 1, so the two kinds cannot collide.
+UPDATE Bookings SET Reference = printf('B%07d', ID) WHERE Reference IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS BookingsReference ON Bookings(Reference);
@@ -0,0 +1,13 @@
+--BookingPassengers table, everyone travelling on a booking in the order they were named
+CREATE TABLE IF NOT EXISTS BookingPassengers (
+    BookingID INTEGER NOT NULL REFERENCES Bookings(ID),
+    Position INTEGER NOT NULL,
+    FirstName TEXT NOT NULL,
+    LastName TEXT NOT NULL,
+    DateOfBirth TEXT,
+    PRIMARY KEY (BookingID, Position)
+);
+
+-- Bookings made so far carry a single passenger
+INSERT INTO BookingPassengers (BookingID, Position, FirstName, LastName)
+SELECT ID, 0, FirstName, LastName FROM Bookings;
@@ -0,0 +1,2 @@
+-- Price of the return legs for one passenger, NULL for round trips booked before it was kept
+ALTER TABLE BookingReturns ADD COLUMN Price REAL;
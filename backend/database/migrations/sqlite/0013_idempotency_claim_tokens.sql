@@ -0,0 +1,3 @@
+-- Token of the request holding the claim, NULL for claims made before tokens were kept, which
+-- can no longer be completed and are taken over after the lease
+ALTER TABLE IdempotencyKeys ADD COLUMN ClaimToken VARCHAR(32);
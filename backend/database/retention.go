@@ -0,0 +1,46 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// RetentionPolicy decides how long pricelists are kept. Zero values disable a limit.
+type RetentionPolicy struct {
+	// Most pricelists kept at once
+	MaxPricelists int
+	// How long a pricelist is kept after it stopped being valid
+	MaxAge time.Duration
+}
+
+// PrunePricelists deletes the pricelists the policy no longer keeps, oldest first, and
+// returns how many were deleted. The latest pricelist is always kept and bookings are
+// never deleted, they carry a snapshot of what was bought.
+func PrunePricelists(store Store, policy RetentionPolicy, now time.Time) (int, error) {
+	pricelists, err := store.Pricelists()
+	if err != nil {
+		return 0, err
+	}
+
+	var evict []PricelistSummary
+	for i, pricelist := range pricelists {
+		if i == 0 {
+			continue
+		}
+		tooMany := policy.MaxPricelists > 0 && i >= policy.MaxPricelists
+		tooOld := policy.MaxAge > 0 && now.Sub(pricelist.ValidUntil) > policy.MaxAge
+		if tooMany || tooOld {
+			evict = append(evict, pricelist)
+		}
+	}
+
+	deleted := 0
+	for i := len(evict) - 1; i >= 0; i-- {
+		if err := store.DeletePricelist(evict[i].ID); err != nil {
+			return deleted, err
+		}
+		log.Printf("Evicted pricelist %s valid until %s", evict[i].ID, evict[i].ValidUntil.Format(time.RFC3339))
+		deleted++
+	}
+	return deleted, nil
+}
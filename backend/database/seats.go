@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+	"space-travel/structs"
+)
+
+var ErrSoldOut = errors.New("Not enough seats left")
+
+// SeatCapacity is how many seats every provider flight has
+type SeatCapacity struct {
+	Default int
+	// Seats per flight of a company, by company name, replacing the default
+	Companies map[string]int
+}
+
+// Seats returns the capacity of a flight of the given company
+func (c SeatCapacity) Seats(companyName string) int {
+	if seats, ok := c.Companies[companyName]; ok {
+		return seats
+	}
+	return c.Default
+}
+
+// SeatQuery says how many seats a search needs and how many every flight has
+type SeatQuery struct {
+	Capacity   SeatCapacity
+	Passengers int
+	// Whether options without enough seats are returned flagged instead of left out
+	IncludeSoldOut bool
+}
+
+// Fills in the seats left on every option and drops the sold out ones unless they are asked for.
+// Seats change with every booking, so this runs after the route cache.
+func annotateSeats(store Store, pricelistID string, routes []structs.PossibleRoute, seats SeatQuery) ([]structs.PossibleRoute, error) {
+	booked, err := store.BookedSeats(pricelistID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []structs.PossibleRoute{}
+	for _, route := range routes {
+		for i, provider := range route.Providers {
+			left := seats.Capacity.Seats(provider.CompanyName) - booked[provider.ID]
+			if left < 0 {
+				left = 0
+			}
+			route.Providers[i].SeatsLeft = left
+			if i == 0 || left < route.SeatsLeft {
+				route.SeatsLeft = left
+			}
+		}
+		route.SoldOut = route.SeatsLeft < seats.Passengers
+		if !route.SoldOut || seats.IncludeSoldOut {
+			available = append(available, route)
+		}
+	}
+	return available, nil
+}
+
+// Every flight of a booking, the return trip included
+func bookingLegs(booking structs.Booking) []structs.BookedLeg {
+	legs := append([]structs.BookedLeg{}, booking.Legs...)
+	if booking.Return != nil {
+		legs = append(legs, booking.Return.Legs...)
+	}
+	return legs
+}
+
+// Every passenger takes a seat, bookings from before group bookings have one
+func bookingSeats(booking structs.Booking) int {
+	if len(booking.Passengers) == 0 {
+		return 1
+	}
+	return len(booking.Passengers)
+}
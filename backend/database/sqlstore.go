@@ -0,0 +1,1149 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"math"
+	"space-travel/calculations"
+	"space-travel/structs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL flavour of a database driver
+type dialect struct {
+	driver string
+	// Directory below migrations holding the schema of this dialect
+	migrations string
+	// Whether placeholders are numbered ($1, $2) instead of question marks
+	numberedPlaceholders bool
+}
+
+var (
+	sqliteDialect   = dialect{driver: "sqlite3", migrations: "sqlite"}
+	postgresDialect = dialect{driver: "postgres", migrations: "postgres", numberedPlaceholders: true}
+)
+
+// Queries are written with question marks and rewritten for dialects that number their placeholders
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLStore keeps everything in a SQLite or PostgreSQL database
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// NewSQLiteStore opens the SQLite database file at path, creating and migrating it as needed
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	return openSQLStore(sqliteDialect, path)
+}
+
+// NewPostgresStore connects to PostgreSQL with the given connection string and migrates the schema
+func NewPostgresStore(dsn string) (*SQLStore, error) {
+	return openSQLStore(postgresDialect, dsn)
+}
+
+func openSQLStore(d dialect, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db, d); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+// AddBooking inserts a new booking into the database
+func (s *SQLStore) AddBooking(booking structs.Booking, capacity SeatCapacity) (structs.Booking, error) {
+	booking, err := newConfirmedBooking(booking, time.Now())
+	if err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to insert booking: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to insert booking: %v", err)
+	}
+	bookingID, err := s.insertBooking(tx, booking)
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+
+	// Seats are taken in the same transaction, so a sold out flight leaves no booking behind
+	for _, leg := range bookingLegs(booking) {
+		if err := s.reserveSeats(tx, leg, bookingSeats(booking), capacity.Seats(leg.CompanyName)); err != nil {
+			tx.Rollback()
+			return structs.Booking{}, err
+		}
+		_, err := tx.Exec(s.dialect.rebind("INSERT INTO BookingSeats (BookingID, ProviderID, Seats) VALUES (?, ?, ?)"),
+			bookingID, leg.ProviderID, bookingSeats(booking))
+		if err != nil {
+			tx.Rollback()
+			return structs.Booking{}, fmt.Errorf("failed to reserve seats: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to insert booking: %v", err)
+	}
+	return booking, nil
+}
+
+// Inserts a booking with its passengers and return trip, returning its ID
+func (s *SQLStore) insertBooking(tx *sql.Tx, booking structs.Booking) (int64, error) {
+	insertBookingSQL := `
+		INSERT INTO Bookings (
+			Reference,
+			Status,
+			CompanyNames,
+			StartTime,
+			FirstName,
+			LastName,
+			TotalPrice,
+			TotalDuration,
+			PricelistID,
+			FromCity,
+			DestinationCity,
+			BookedAt,
+			Snapshot
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING ID
+	`
+
+	// The booking outlives its pricelist, so it keeps everything that was bought
+	snapshot, err := json.Marshal(booking)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert booking: %v", err)
+	}
+
+	var bookingID int64
+	err = tx.QueryRow(
+		s.dialect.rebind(insertBookingSQL),
+		booking.Reference,
+		booking.Status,
+		calculations.ArrayToString(booking.CompanyNames),
+		booking.StartTime,
+		booking.FirstName,
+		booking.LastName,
+		booking.TotalPrice,
+		booking.TotalDuration,
+		booking.PricelistID,
+		booking.Routes.From,
+		booking.Routes.Destination,
+		booking.BookedAt,
+		string(snapshot),
+	).Scan(&bookingID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert booking: %v", err)
+	}
+
+	// The whole group is stored with the booking or not at all
+	for i, passenger := range booking.Passengers {
+		if err := s.insertBookingPassenger(tx, bookingID, i, passenger); err != nil {
+			return 0, fmt.Errorf("failed to insert passenger: %v", err)
+		}
+	}
+
+	// The return direction of a round trip belongs to the same reservation
+	if booking.Return != nil {
+		if err := s.insertBookingReturn(tx, bookingID, *booking.Return); err != nil {
+			return 0, fmt.Errorf("failed to insert return trip: %v", err)
+		}
+	}
+	return bookingID, nil
+}
+
+func (s *SQLStore) insertBookingReturn(tx *sql.Tx, bookingID int64, trip structs.ReturnTrip) error {
+	_, err := tx.Exec(s.dialect.rebind(`
+		INSERT INTO BookingReturns (
+			BookingID,
+			CompanyNames,
+			StartTime,
+			TotalDuration,
+			FromCity,
+			DestinationCity,
+			Price
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`),
+		bookingID,
+		calculations.ArrayToString(trip.CompanyNames),
+		trip.StartTime,
+		trip.TotalDuration,
+		trip.Routes.From,
+		trip.Routes.Destination,
+		trip.Price,
+	)
+	return err
+}
+
+func (s *SQLStore) insertBookingPassenger(tx *sql.Tx, bookingID int64, position int, passenger structs.Passenger) error {
+	var dateOfBirth sql.NullString
+	if passenger.DateOfBirth != "" {
+		dateOfBirth = sql.NullString{String: passenger.DateOfBirth, Valid: true}
+	}
+	_, err := tx.Exec(s.dialect.rebind(`
+		INSERT INTO BookingPassengers (BookingID, Position, FirstName, LastName, DateOfBirth)
+		VALUES (?, ?, ?, ?, ?)
+	`), bookingID, position, passenger.FirstName, passenger.LastName, dateOfBirth)
+	return err
+}
+
+// Takes seats on a flight unless that would exceed its capacity
+func (s *SQLStore) reserveSeats(tx *sql.Tx, leg structs.BookedLeg, seats int, capacity int) error {
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO SeatInventory (ProviderID, Booked) VALUES (?, 0) ON CONFLICT (ProviderID) DO NOTHING"), leg.ProviderID)
+	if err != nil {
+		return fmt.Errorf("failed to reserve seats: %v", err)
+	}
+	// Checking and taking the seats in one statement keeps concurrent bookings from overselling
+	result, err := tx.Exec(s.dialect.rebind("UPDATE SeatInventory SET Booked = Booked + ? WHERE ProviderID = ? AND Booked + ? <= ?"),
+		seats, leg.ProviderID, seats, capacity)
+	if err != nil {
+		return fmt.Errorf("failed to reserve seats: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to reserve seats: %v", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w on the %s flight from %s to %s", ErrSoldOut, leg.CompanyName, leg.From, leg.To)
+	}
+	return nil
+}
+
+// Gives back the seats recorded for a booking in BookingSeats or for a hold in HoldSeats
+func (s *SQLStore) releaseSeats(tx *sql.Tx, seatsTable string, ownerColumn string, ownerID int64) error {
+	_, err := tx.Exec(s.dialect.rebind(fmt.Sprintf(`
+		UPDATE SeatInventory SET Booked = Booked - (
+			SELECT SUM(Seats) FROM %[1]s
+			WHERE %[1]s.%[2]s = ? AND %[1]s.ProviderID = SeatInventory.ProviderID
+		)
+		WHERE ProviderID IN (SELECT ProviderID FROM %[1]s WHERE %[2]s = ?)
+	`, seatsTable, ownerColumn)), ownerID, ownerID)
+	return err
+}
+
+func (s *SQLStore) AddHold(hold structs.Hold, capacity SeatCapacity) (structs.Hold, error) {
+	reference, err := newBookingReference()
+	if err != nil {
+		return structs.Hold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+	hold.Reference = reference
+	hold.Status = HoldActive
+	snapshot, err := json.Marshal(hold.Booking)
+	if err != nil {
+		return structs.Hold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return structs.Hold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+	var holdID int64
+	err = tx.QueryRow(s.dialect.rebind(`
+		INSERT INTO Holds (Reference, Status, CreatedAt, ExpiresAt, Snapshot)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING ID
+	`), hold.Reference, hold.Status, hold.CreatedAt, hold.ExpiresAt, string(snapshot)).Scan(&holdID)
+	if err != nil {
+		tx.Rollback()
+		return structs.Hold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+
+	for _, leg := range bookingLegs(hold.Booking) {
+		if err := s.reserveSeats(tx, leg, bookingSeats(hold.Booking), capacity.Seats(leg.CompanyName)); err != nil {
+			tx.Rollback()
+			return structs.Hold{}, err
+		}
+		_, err := tx.Exec(s.dialect.rebind("INSERT INTO HoldSeats (HoldID, ProviderID, Seats) VALUES (?, ?, ?)"),
+			holdID, leg.ProviderID, bookingSeats(hold.Booking))
+		if err != nil {
+			tx.Rollback()
+			return structs.Hold{}, fmt.Errorf("failed to reserve seats: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return structs.Hold{}, fmt.Errorf("failed to insert hold: %v", err)
+	}
+	return hold, nil
+}
+
+func (s *SQLStore) Hold(reference string) (structs.Hold, error) {
+	var hold structs.Hold
+	var snapshot string
+	var bookingReference sql.NullString
+	err := s.queryRow(`
+		SELECT Holds.Reference, Holds.Status, Holds.CreatedAt, Holds.ExpiresAt, Holds.Snapshot, Bookings.Reference
+		FROM Holds
+		LEFT JOIN Bookings ON Bookings.ID = Holds.BookingID
+		WHERE Holds.Reference = ?
+	`, reference).Scan(&hold.Reference, &hold.Status, &hold.CreatedAt, &hold.ExpiresAt, &snapshot, &bookingReference)
+	if errors.Is(err, sql.ErrNoRows) {
+		return structs.Hold{}, ErrUnknownHold
+	}
+	if err != nil {
+		return structs.Hold{}, err
+	}
+	if err := json.Unmarshal([]byte(snapshot), &hold.Booking); err != nil {
+		return structs.Hold{}, err
+	}
+	hold.BookingReference = bookingReference.String
+	return hold, nil
+}
+
+func (s *SQLStore) ConfirmHold(reference string, booking structs.Booking, now time.Time) (structs.Booking, error) {
+	booking, err := newConfirmedBooking(booking, now)
+	if err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to confirm hold: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to confirm hold: %v", err)
+	}
+	var hold structs.Hold
+	var holdID int64
+	err = tx.QueryRow(s.dialect.rebind("SELECT ID, Status, ExpiresAt FROM Holds WHERE Reference = ?"), reference).
+		Scan(&holdID, &hold.Status, &hold.ExpiresAt)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return structs.Booking{}, ErrUnknownHold
+		}
+		return structs.Booking{}, err
+	}
+	if err := checkHoldActive(hold, now); err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+
+	// Claiming the hold first keeps it from being confirmed twice or released by the sweeper meanwhile
+	result, err := tx.Exec(s.dialect.rebind("UPDATE Holds SET Status = ? WHERE ID = ? AND Status = ?"), HoldConfirmed, holdID, HoldActive)
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		current, err := s.Hold(reference)
+		if err != nil {
+			return structs.Booking{}, err
+		}
+		if current.Status == HoldConfirmed {
+			return structs.Booking{}, ErrHoldConfirmed
+		}
+		return structs.Booking{}, ErrHoldExpired
+	}
+
+	bookingID, err := s.insertBooking(tx, booking)
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+	// The held seats now belong to the booking, so cancelling it gives them back
+	statements := []string{
+		"INSERT INTO BookingSeats (BookingID, ProviderID, Seats) SELECT ?, ProviderID, Seats FROM HoldSeats WHERE HoldID = ?",
+		"UPDATE Holds SET BookingID = ? WHERE ID = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(s.dialect.rebind(statement), bookingID, holdID); err != nil {
+			tx.Rollback()
+			return structs.Booking{}, fmt.Errorf("failed to confirm hold: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return structs.Booking{}, fmt.Errorf("failed to confirm hold: %v", err)
+	}
+	return booking, nil
+}
+
+func (s *SQLStore) ReleaseExpiredHolds(now time.Time) (int, error) {
+	rows, err := s.query("SELECT ID, ExpiresAt FROM Holds WHERE Status = ?", HoldActive)
+	if err != nil {
+		return 0, err
+	}
+	var expired []int64
+	for rows.Next() {
+		var holdID int64
+		var expiresAt time.Time
+		if err := rows.Scan(&holdID, &expiresAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !expiresAt.After(now) {
+			expired = append(expired, holdID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, holdID := range expired {
+		ok, err := s.releaseHold(holdID)
+		if err != nil {
+			return released, err
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Expires a hold and gives back its seats, reporting false when it was confirmed meanwhile
+func (s *SQLStore) releaseHold(holdID int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(s.dialect.rebind("UPDATE Holds SET Status = ? WHERE ID = ? AND Status = ?"), HoldExpired, holdID, HoldActive)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if err := s.releaseSeats(tx, "HoldSeats", "HoldID", holdID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *SQLStore) ClaimIdempotencyKey(key string, requestHash string, now time.Time, retention time.Duration, lease time.Duration) (IdempotentRequest, bool, error) {
+	if _, err := s.exec("DELETE FROM IdempotencyKeys WHERE CreatedAt < ?", now.Add(-retention).UTC()); err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	if lease > 0 {
+		_, err := s.exec("DELETE FROM IdempotencyKeys WHERE StatusCode IS NULL AND CreatedAt < ?", now.Add(-lease).UTC())
+		if err != nil {
+			return IdempotentRequest{}, false, err
+		}
+	}
+	token, err := newClaimToken()
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	// Inserting is what claims the key, so of two requests racing with the same key only one wins
+	result, err := s.exec(`
+		INSERT INTO IdempotencyKeys (IdempotencyKey, RequestHash, CreatedAt, ClaimToken)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (IdempotencyKey) DO NOTHING
+	`, key, requestHash, now.UTC(), token)
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	if inserted == 1 {
+		return IdempotentRequest{Key: key, RequestHash: requestHash, CreatedAt: now.UTC(), Token: token}, true, nil
+	}
+
+	request := IdempotentRequest{Key: key}
+	var statusCode sql.NullInt64
+	var contentType, response, reference sql.NullString
+	err = s.queryRow(`
+		SELECT RequestHash, CreatedAt, StatusCode, ContentType, Response, Reference
+		FROM IdempotencyKeys WHERE IdempotencyKey = ?
+	`, key).Scan(&request.RequestHash, &request.CreatedAt, &statusCode, &contentType, &response, &reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released by the request that held it just now
+		return s.ClaimIdempotencyKey(key, requestHash, now, retention, lease)
+	}
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	request.StatusCode = int(statusCode.Int64)
+	request.ContentType = contentType.String
+	request.Response = []byte(response.String)
+	request.Reference = reference.String
+	return request, false, nil
+}
+
+func (s *SQLStore) CompleteIdempotencyKey(request IdempotentRequest) error {
+	_, err := s.exec(`
+		UPDATE IdempotencyKeys SET StatusCode = ?, ContentType = ?, Response = ?, Reference = ?
+		WHERE IdempotencyKey = ? AND ClaimToken = ? AND StatusCode IS NULL
+	`, request.StatusCode, request.ContentType, string(request.Response), request.Reference, request.Key, request.Token)
+	return err
+}
+
+func (s *SQLStore) ReleaseIdempotencyKey(request IdempotentRequest) error {
+	_, err := s.exec("DELETE FROM IdempotencyKeys WHERE IdempotencyKey = ? AND ClaimToken = ? AND StatusCode IS NULL",
+		request.Key, request.Token)
+	return err
+}
+
+func (s *SQLStore) BookedSeats(pricelistID string) (map[string]int, error) {
+	rows, err := s.query(`
+		SELECT SeatInventory.ProviderID, SeatInventory.Booked
+		FROM SeatInventory
+		JOIN Providers ON Providers.ID = SeatInventory.ProviderID
+		JOIN Legs ON Providers.LegID = Legs.ID
+		WHERE Legs.PriceListID = ?
+	`, pricelistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	booked := map[string]int{}
+	for rows.Next() {
+		var providerID string
+		var seats int
+		if err := rows.Scan(&providerID, &seats); err != nil {
+			return nil, err
+		}
+		booked[providerID] = seats
+	}
+	return booked, rows.Err()
+}
+
+// Columns read for a booking, the return trip ones are NULL for one-way bookings
+const bookingColumns = `
+	Bookings.Reference, Bookings.Status, Bookings.BookedAt, Bookings.CancelledAt, Bookings.Snapshot,
+	Bookings.CompanyNames, Bookings.StartTime, Bookings.FirstName, Bookings.LastName, Bookings.TotalPrice,
+	Bookings.TotalDuration, Bookings.PricelistID, Bookings.FromCity, Bookings.DestinationCity,
+	BookingReturns.CompanyNames, BookingReturns.StartTime, BookingReturns.TotalDuration,
+	BookingReturns.FromCity, BookingReturns.DestinationCity, BookingReturns.Price
+	FROM Bookings
+	LEFT JOIN BookingReturns ON BookingReturns.BookingID = Bookings.ID`
+
+func scanBooking(row interface{ Scan(...interface{}) error }) (structs.Booking, error) {
+	var booking structs.Booking
+	var bookedAt, cancelledAt sql.NullTime
+	var snapshot sql.NullString
+	var companyNames string
+	var returnCompanyNames, returnStartTime, returnDuration, returnFrom, returnDestination sql.NullString
+	var returnPrice sql.NullFloat64
+	err := row.Scan(
+		&booking.Reference, &booking.Status, &bookedAt, &cancelledAt, &snapshot,
+		&companyNames, &booking.StartTime, &booking.FirstName, &booking.LastName, &booking.TotalPrice,
+		&booking.TotalDuration, &booking.PricelistID, &booking.Routes.From, &booking.Routes.Destination,
+		&returnCompanyNames, &returnStartTime, &returnDuration, &returnFrom, &returnDestination, &returnPrice,
+	)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+
+	if snapshot.Valid {
+		// The snapshot holds everything that was bought, only the status changes afterwards
+		reference, status := booking.Reference, booking.Status
+		booking = structs.Booking{}
+		if err := json.Unmarshal([]byte(snapshot.String), &booking); err != nil {
+			return structs.Booking{}, err
+		}
+		booking.Reference, booking.Status = reference, status
+	} else {
+		// Bookings made before snapshots only have their columns
+		booking.CompanyNames = stringToArray(companyNames)
+		if returnStartTime.Valid {
+			booking.Return = &structs.ReturnTrip{
+				CompanyNames:  stringToArray(returnCompanyNames.String),
+				StartTime:     returnStartTime.String,
+				TotalDuration: returnDuration.String,
+				Price:         returnPrice.Float64,
+				Routes:        structs.Routes{From: returnFrom.String, Destination: returnDestination.String},
+			}
+		}
+	}
+	// Round trips booked before the return price was kept still have the legs it comes from
+	if booking.Return != nil && booking.Return.Price == 0 {
+		booking.Return.Price = math.Round(legsPrice(booking.Return.Legs)*100) / 100
+	}
+	// Bookings made before group bookings have a single passenger
+	if len(booking.Passengers) == 0 {
+		booking.Passengers = []structs.Passenger{{FirstName: booking.FirstName, LastName: booking.LastName}}
+		booking.PricePerPassenger = booking.TotalPrice
+	}
+	booking.BookedAt = bookedAt.Time
+	booking.CancelledAt = nil
+	if cancelledAt.Valid {
+		at := cancelledAt.Time
+		booking.CancelledAt = &at
+	}
+	return booking, nil
+}
+
+// Reverses calculations.ArrayToString
+func stringToArray(value string) []string {
+	value = strings.Trim(value, "'")
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func (s *SQLStore) Booking(reference string) (structs.Booking, error) {
+	booking, err := scanBooking(s.queryRow("SELECT "+bookingColumns+" WHERE Bookings.Reference = ?", reference))
+	if errors.Is(err, sql.ErrNoRows) {
+		return structs.Booking{}, ErrUnknownBooking
+	}
+	return booking, err
+}
+
+func (s *SQLStore) Bookings(filter BookingFilter) ([]structs.Booking, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Passenger != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM BookingPassengers
+			WHERE BookingPassengers.BookingID = Bookings.ID
+			AND LOWER(BookingPassengers.FirstName || ' ' || BookingPassengers.LastName) LIKE ? ESCAPE '\'
+		)`)
+		args = append(args, "%"+escapeLike(strings.ToLower(filter.Passenger))+"%")
+	}
+	if filter.From != "" {
+		conditions = append(conditions, "Bookings.FromCity = ?")
+		args = append(args, filter.From)
+	}
+	if filter.Destination != "" {
+		conditions = append(conditions, "Bookings.DestinationCity = ?")
+		args = append(args, filter.Destination)
+	}
+	if filter.Company != "" {
+		// Company names are stored as 'First,Second'
+		conditions = append(conditions, `',' || REPLACE(Bookings.CompanyNames, '''', '') || ',' LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+escapeLike(filter.Company)+",%")
+	}
+	if filter.Date != "" {
+		conditions = append(conditions, `Bookings.StartTime LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(filter.Date)+"%")
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "Bookings.Status = ?")
+		args = append(args, filter.Status)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM Bookings"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + bookingColumns + where + " ORDER BY Bookings.ID DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	bookings := []structs.Booking{}
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		bookings = append(bookings, booking)
+	}
+	return bookings, total, rows.Err()
+}
+
+// Makes a value match itself literally in a LIKE pattern
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func (s *SQLStore) CancelBooking(reference string, at time.Time) (structs.Booking, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return structs.Booking{}, err
+	}
+
+	var bookingID int64
+	err = tx.QueryRow(s.dialect.rebind("SELECT ID FROM Bookings WHERE Reference = ?"), reference).Scan(&bookingID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return structs.Booking{}, ErrUnknownBooking
+		}
+		return structs.Booking{}, err
+	}
+
+	result, err := tx.Exec(s.dialect.rebind("UPDATE Bookings SET Status = ?, CancelledAt = ? WHERE ID = ? AND Status = ?"),
+		BookingCancelled, at.UTC(), bookingID, BookingConfirmed)
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		booking, err := s.Booking(reference)
+		if err != nil {
+			return structs.Booking{}, err
+		}
+		return booking, ErrAlreadyCancelled
+	}
+
+	// Bookings made before seats were tracked hold none, so nothing is given back for them
+	if err := s.releaseSeats(tx, "BookingSeats", "BookingID", bookingID); err != nil {
+		tx.Rollback()
+		return structs.Booking{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return structs.Booking{}, err
+	}
+	return s.Booking(reference)
+}
+
+// InsertPricelist imports a pricelist in a single transaction, so it is either fully stored or not at all
+func (s *SQLStore) InsertPricelist(pricelist structs.Pricelist) error {
+	exists, err := s.pricelistExists(pricelist.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmts, err := s.preparePricelistStatements(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmts.Close()
+
+	if err := insertPricelistRows(stmts, pricelist); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertPricelistRows(stmts *pricelistStatements, pricelist structs.Pricelist) error {
+	for _, leg := range pricelist.Legs {
+		fromLocation := leg.RouteInfo.From
+		toLocation := leg.RouteInfo.To
+		if err := insertLocation(stmts, fromLocation, leg.ID); err != nil {
+			return err
+		}
+		if err := insertLocation(stmts, toLocation, leg.ID); err != nil {
+			return err
+		}
+	}
+
+	// Insert Company, RouteInfo, and Route data
+	for _, leg := range pricelist.Legs {
+		for _, provider := range leg.Providers {
+			if err := insertCompany(stmts, provider.Company, pricelist.ID); err != nil {
+				return err
+			}
+		}
+		if err := insertRouteInfo(stmts, leg.RouteInfo, leg.ID); err != nil {
+			return err
+		}
+		if err := insertRoute(stmts, leg, pricelist.ID); err != nil {
+			return err
+		}
+	}
+
+	// Insert Provider data
+	for _, leg := range pricelist.Legs {
+		for _, provider := range leg.Providers {
+			if err := insertProvider(stmts, provider, leg.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Insert Pricelist data last, the other rows are only visible through it
+	return insertPricelist(stmts, pricelist)
+}
+
+// Prepared statements reused for every row of a pricelist import
+type pricelistStatements struct {
+	location  *sql.Stmt
+	company   *sql.Stmt
+	routeInfo *sql.Stmt
+	route     *sql.Stmt
+	provider  *sql.Stmt
+	pricelist *sql.Stmt
+}
+
+func (s *SQLStore) preparePricelistStatements(tx *sql.Tx) (*pricelistStatements, error) {
+	stmts := &pricelistStatements{}
+	queries := []struct {
+		target **sql.Stmt
+		query  string
+	}{
+		{&stmts.location, "INSERT INTO locations (id, name, legID) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING"},
+		{&stmts.company, "INSERT INTO companies (id, name, pricelistID) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING"},
+		{&stmts.routeInfo, "INSERT INTO routeInfos (id, FromID, ToID, distance, LegID) VALUES (?, ?, ?, ?, ?)"},
+		{&stmts.route, "INSERT INTO legs (id, routeInfoId, PriceListID) VALUES (?, ?, ?)"},
+		{&stmts.provider, "INSERT INTO providers (id, companyID, price, flightStart, flightEnd, legID) VALUES (?, ?, ?, ?, ?, ?)"},
+		{&stmts.pricelist, "INSERT INTO pricelists (id, validUntil) VALUES (?, ?)"},
+	}
+	for _, q := range queries {
+		stmt, err := tx.Prepare(s.dialect.rebind(q.query))
+		if err != nil {
+			stmts.Close()
+			return nil, err
+		}
+		*q.target = stmt
+	}
+	return stmts, nil
+}
+
+func (s *pricelistStatements) Close() {
+	for _, stmt := range []*sql.Stmt{s.location, s.company, s.routeInfo, s.route, s.provider, s.pricelist} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (s *SQLStore) RecordRejectedPricelist(pricelist structs.Pricelist, reason string) error {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM RejectedPricelists WHERE PricelistID = ? AND Reason = ?", pricelist.ID, reason).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(pricelist)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec("INSERT INTO RejectedPricelists (PricelistID, RejectedAt, Reason, Payload) VALUES (?, ?, ?, ?)",
+		pricelist.ID, time.Now().UTC(), reason, string(payload))
+	return err
+}
+
+func (s *SQLStore) pricelistExists(pricelistID string) (bool, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM pricelists WHERE ID = ?", pricelistID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SQLStore) Pricelist(pricelistID string) (PricelistSummary, error) {
+	var pricelist PricelistSummary
+	err := s.queryRow("SELECT ID, ValidUntil FROM Pricelists WHERE ID = ?", pricelistID).Scan(&pricelist.ID, &pricelist.ValidUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PricelistSummary{}, ErrNoPricelist
+	}
+	return pricelist, err
+}
+
+func (s *SQLStore) Pricelists() ([]PricelistSummary, error) {
+	rows, err := s.query("SELECT ID, ValidUntil FROM Pricelists ORDER BY ValidUntil DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pricelists []PricelistSummary
+	for rows.Next() {
+		var pricelist PricelistSummary
+		if err := rows.Scan(&pricelist.ID, &pricelist.ValidUntil); err != nil {
+			return nil, err
+		}
+		pricelists = append(pricelists, pricelist)
+	}
+	return pricelists, rows.Err()
+}
+
+func (s *SQLStore) DeletePricelist(pricelistID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		// Locations and companies can be shared with newer pricelists that reuse their IDs,
+		// so they are detached here and only deleted below once nothing uses them
+		"UPDATE Locations SET LegID = NULL WHERE LegID IN (SELECT ID FROM Legs WHERE PriceListID = ?)",
+		"UPDATE Companies SET PriceListID = NULL WHERE PriceListID = ?",
+		"DELETE FROM CachedRoutes WHERE PricelistID = ?",
+		"DELETE FROM SeatInventory WHERE ProviderID IN (SELECT Providers.ID FROM Providers JOIN Legs ON Providers.LegID = Legs.ID WHERE Legs.PriceListID = ?)",
+		"DELETE FROM Providers WHERE LegID IN (SELECT ID FROM Legs WHERE PriceListID = ?)",
+		"DELETE FROM RouteInfos WHERE LegID IN (SELECT ID FROM Legs WHERE PriceListID = ?)",
+		"DELETE FROM Legs WHERE PriceListID = ?",
+		"DELETE FROM Pricelists WHERE ID = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(s.dialect.rebind(statement), pricelistID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	orphans := []string{
+		"DELETE FROM Locations WHERE NOT EXISTS (SELECT 1 FROM RouteInfos WHERE RouteInfos.FromID = Locations.ID OR RouteInfos.ToID = Locations.ID)",
+		"DELETE FROM Companies WHERE NOT EXISTS (SELECT 1 FROM Providers WHERE Providers.CompanyID = Companies.ID)",
+	}
+	for _, statement := range orphans {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Function to insert Location data into the database, skipping locations that already exist
+func insertLocation(stmts *pricelistStatements, location structs.Location, legID string) error {
+	_, err := stmts.location.Exec(location.ID, location.Name, legID)
+	return err
+}
+
+// Function to insert Company data into the database, skipping companies that already exist
+func insertCompany(stmts *pricelistStatements, company structs.Company, pricelistID string) error {
+	_, err := stmts.company.Exec(company.ID, company.Name, pricelistID)
+	return err
+}
+
+// Function to insert RouteInfo data into the database
+func insertRouteInfo(stmts *pricelistStatements, routeInfo structs.RouteInfo, legID string) error {
+	_, err := stmts.routeInfo.Exec(routeInfo.ID, routeInfo.From.ID, routeInfo.To.ID, routeInfo.Distance, legID)
+	return err
+}
+
+// Function to insert Route data into the database
+func insertRoute(stmts *pricelistStatements, route structs.Leg, priceListID string) error {
+	_, err := stmts.route.Exec(route.ID, route.RouteInfo.ID, priceListID)
+	return err
+}
+
+// Function to insert Provider data into the database
+func insertProvider(stmts *pricelistStatements, provider structs.Provider, legID string) error {
+	_, err := stmts.provider.Exec(provider.ID, provider.Company.ID, provider.Price, provider.FlightStart, provider.FlightEnd, legID)
+	return err
+}
+
+// Function to insert Pricelist data into the database
+func insertPricelist(stmts *pricelistStatements, pricelist structs.Pricelist) error {
+	_, err := stmts.pricelist.Exec(pricelist.ID, pricelist.ValidUntil)
+	return err
+}
+
+func (s *SQLStore) RouteGraph(pricelistID string) (calculations.Graph, error) {
+	query := `
+		SELECT DISTINCT LocationsFrom.Name, LocationsTo.Name
+		FROM Legs
+		JOIN RouteInfos ON Legs.RouteInfoID = RouteInfos.ID
+		JOIN Locations LocationsFrom ON RouteInfos.FromID = LocationsFrom.ID
+		JOIN Locations LocationsTo ON RouteInfos.ToID = LocationsTo.ID
+		WHERE Legs.PriceListID = ?
+		ORDER BY LocationsFrom.Name, LocationsTo.Name
+	`
+	rows, err := s.query(query, pricelistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := calculations.Graph{}
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		graph[from] = append(graph[from], to)
+		// Planets that only appear as a destination are still valid endpoints
+		if _, ok := graph[to]; !ok {
+			graph[to] = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+func (s *SQLStore) LegProviders(pricelistID string, from string, destination string) ([]structs.SimplifiedProvider, int, error) {
+	query := `
+		SELECT Providers.ID, Providers.Price, Providers.FlightStart, Providers.FlightEnd,
+			RouteInfos.Distance, Companies.ID, Companies.Name AS CompanyName
+		FROM Legs
+		JOIN RouteInfos ON Legs.RouteInfoID = RouteInfos.ID
+		JOIN Locations LocationsFrom ON RouteInfos.FromID = LocationsFrom.ID
+		JOIN Locations LocationsTo ON RouteInfos.ToID = LocationsTo.ID
+		JOIN Providers ON Legs.ID = Providers.LegID
+		JOIN Companies ON Providers.CompanyID = Companies.ID
+		WHERE Legs.PriceListID = ? AND LocationsFrom.Name = ? AND LocationsTo.Name = ?
+		ORDER BY Providers.FlightStart
+	`
+	rows, err := s.query(query, pricelistID, from, destination)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var simplifiedProviders []structs.SimplifiedProvider
+	var routeDistance = 0
+	for rows.Next() {
+		var providerID, companyName, companyID string
+		var price float64
+		var flightStart, flightEnd time.Time
+		var distance int
+
+		err := rows.Scan(&providerID, &price, &flightStart, &flightEnd, &distance, &companyID, &companyName)
+		if err != nil {
+			return nil, 0, err
+		}
+		if routeDistance == 0 {
+			routeDistance = distance
+		}
+
+		// Add Provider to the slice
+		simplifiedProviders = append(simplifiedProviders, structs.SimplifiedProvider{
+			ID:          providerID,
+			CompanyName: companyName,
+			CompanyID:   companyID,
+			Price:       price,
+			FlightStart: flightStart,
+			FlightEnd:   flightEnd,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return simplifiedProviders, routeDistance, nil
+}
+
+func (s *SQLStore) BookedLeg(pricelistID string, providerID string) (structs.BookedLeg, error) {
+	query := `
+		SELECT Providers.ID, Companies.Name, LocationsFrom.Name, LocationsTo.Name,
+			Providers.Price, Providers.FlightStart, Providers.FlightEnd
+		FROM Providers
+		JOIN Legs ON Providers.LegID = Legs.ID
+		JOIN RouteInfos ON Legs.RouteInfoID = RouteInfos.ID
+		JOIN Locations LocationsFrom ON RouteInfos.FromID = LocationsFrom.ID
+		JOIN Locations LocationsTo ON RouteInfos.ToID = LocationsTo.ID
+		JOIN Companies ON Providers.CompanyID = Companies.ID
+		WHERE Legs.PriceListID = ? AND Providers.ID = ?
+	`
+	var leg structs.BookedLeg
+	err := s.queryRow(query, pricelistID, providerID).Scan(
+		&leg.ProviderID, &leg.CompanyName, &leg.From, &leg.To, &leg.Price, &leg.FlightStart, &leg.FlightEnd)
+	if errors.Is(err, sql.ErrNoRows) {
+		return structs.BookedLeg{}, ErrUnknownProvider
+	}
+	return leg, err
+}
+
+func (s *SQLStore) CachedRoutes(pricelistID string, from string, destination string) (structs.GetResponse, bool, error) {
+	var cachedRoutes string
+	err := s.queryRow("SELECT Routes FROM CachedRoutes WHERE PricelistID = ? AND FromLocation = ? AND ToLocation = ?", pricelistID, from, destination).Scan(&cachedRoutes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return structs.GetResponse{}, false, nil
+	}
+	if err != nil {
+		return structs.GetResponse{}, false, err
+	}
+
+	var cachedData structs.GetResponse
+	if err := json.Unmarshal([]byte(cachedRoutes), &cachedData); err != nil {
+		return structs.GetResponse{}, false, err
+	}
+	return cachedData, true, nil
+}
+
+func (s *SQLStore) CacheRoutes(pricelistID string, from string, destination string, response structs.GetResponse) error {
+	jsonRoutes, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(`
+		INSERT INTO CachedRoutes (ID, PricelistID, FromLocation, ToLocation, Routes)
+		VALUES (?, ?, ?, ?, ?)
+	`, uuid.New().String(), pricelistID, from, destination, string(jsonRoutes))
+	return err
+}
+
+func (s *SQLStore) LatestPricelist() (PricelistSummary, error) {
+	var latest PricelistSummary
+	err := s.queryRow("SELECT ID, ValidUntil FROM Pricelists ORDER BY ValidUntil DESC LIMIT 1").Scan(&latest.ID, &latest.ValidUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PricelistSummary{}, ErrNoPricelist
+		}
+		return PricelistSummary{}, err
+	}
+	return latest, nil
+}
+
+func (s *SQLStore) CleanCache(pricelistID string) error {
+	_, err := s.exec("DELETE FROM CachedRoutes WHERE PricelistID != ?", pricelistID)
+	return err
+}
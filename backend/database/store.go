@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"space-travel/calculations"
+	"space-travel/structs"
+	"time"
+)
+
+// Storage backends that can be selected with Open
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// PricelistSummary identifies a stored pricelist and how long it is valid
+type PricelistSummary struct {
+	ID         string
+	ValidUntil time.Time
+}
+
+// Store keeps pricelists, the routes cached for them and the bookings made against them
+type Store interface {
+	// InsertPricelist stores a pricelist with all its legs. Storing a pricelist that is
+	// already there does nothing.
+	InsertPricelist(pricelist structs.Pricelist) error
+	// LatestPricelist returns the pricelist that stays valid the longest, or ErrNoPricelist
+	LatestPricelist() (PricelistSummary, error)
+	// Pricelist returns a stored pricelist, or ErrNoPricelist
+	Pricelist(pricelistID string) (PricelistSummary, error)
+	// Pricelists lists every stored pricelist, the one valid the longest first
+	Pricelists() ([]PricelistSummary, error)
+	// DeletePricelist removes a pricelist with its legs and cached routes. Bookings made
+	// against it are kept.
+	DeletePricelist(pricelistID string) error
+	// RecordRejectedPricelist keeps a pricelist that failed validation for later inspection.
+	// A pricelist rejected for the same reason before is not stored again.
+	RecordRejectedPricelist(pricelist structs.Pricelist, reason string) error
+
+	// RouteGraph builds the planet graph from the legs of a pricelist
+	RouteGraph(pricelistID string) (calculations.Graph, error)
+	// LegProviders returns the providers flying directly between two planets in a pricelist,
+	// earliest departure first, together with the distance between the planets
+	LegProviders(pricelistID string, from string, destination string) ([]structs.SimplifiedProvider, int, error)
+
+	// BookedLeg looks up a provider of a pricelist, or returns ErrUnknownProvider
+	BookedLeg(pricelistID string, providerID string) (structs.BookedLeg, error)
+
+	// CachedRoutes returns the response cached for a search, reporting false when there is none
+	CachedRoutes(pricelistID string, from string, destination string) (structs.GetResponse, bool, error)
+	CacheRoutes(pricelistID string, from string, destination string, response structs.GetResponse) error
+	// CleanCache drops the cached responses of every pricelist except the given one
+	CleanCache(pricelistID string) error
+
+	// AddBooking stores a confirmed booking together with its return trip and a snapshot of
+	// what was bought, returning it with its new reference. It takes a seat per passenger on
+	// every flight and stores nothing when one of them has too few left, returning ErrSoldOut.
+	AddBooking(booking structs.Booking, capacity SeatCapacity) (structs.Booking, error)
+	// Booking looks up a booking by reference, or returns ErrUnknownBooking
+	Booking(reference string) (structs.Booking, error)
+	// Bookings returns the requested page of matching bookings, newest first, and how many matched
+	Bookings(filter BookingFilter) ([]structs.Booking, int, error)
+	// CancelBooking marks a booking as cancelled at the given time, gives its seats back and
+	// returns it. Cancelling twice returns ErrAlreadyCancelled.
+	CancelBooking(reference string, at time.Time) (structs.Booking, error)
+	// AddHold stores a hold with a new reference and takes its seats like AddBooking does
+	AddHold(hold structs.Hold, capacity SeatCapacity) (structs.Hold, error)
+	// Hold looks up a hold by reference, or returns ErrUnknownHold
+	Hold(reference string) (structs.Hold, error)
+	// ConfirmHold stores the booking of a hold and moves the held seats to it. It returns
+	// ErrHoldExpired or ErrHoldConfirmed when the hold is no longer active at the given time.
+	ConfirmHold(reference string, booking structs.Booking, now time.Time) (structs.Booking, error)
+	// ReleaseExpiredHolds gives back the seats of the holds that ran out by the given time
+	// and returns how many there were
+	ReleaseExpiredHolds(now time.Time) (int, error)
+
+	// ClaimIdempotencyKey records that a request with the given key is being handled and forgets
+	// keys older than the retention, as well as claims still unanswered after the lease, whose
+	// request is taken to have died. A lease of 0 keeps claims until the retention. When the key
+	// is still known it returns the earlier request instead, and false. A new claim gets a token
+	// that completing or releasing it has to present.
+	ClaimIdempotencyKey(key string, requestHash string, now time.Time, retention time.Duration, lease time.Duration) (IdempotentRequest, bool, error)
+	// CompleteIdempotencyKey stores the response of a claimed request. A claim that was answered,
+	// released or taken over in the meantime is left alone.
+	CompleteIdempotencyKey(request IdempotentRequest) error
+	// ReleaseIdempotencyKey forgets an unanswered claim, so a request that failed can be retried
+	ReleaseIdempotencyKey(request IdempotentRequest) error
+
+	// BookedSeats returns the seats taken by bookings and holds on the provider flights of a
+	// pricelist, by provider ID
+	BookedSeats(pricelistID string) (map[string]int, error)
+
+	Close() error
+}
+
+// Open connects to the store of the given driver. The location is the database file for
+// SQLite and the connection string for PostgreSQL, the memory store ignores it.
+func Open(driver string, location string) (Store, error) {
+	switch driver {
+	case DriverSQLite:
+		return NewSQLiteStore(location)
+	case DriverPostgres:
+		return NewPostgresStore(location)
+	case DriverMemory:
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
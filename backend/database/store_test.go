@@ -0,0 +1,1057 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"space-travel/structs"
+	"testing"
+	"time"
+)
+
+// Every store has to behave the same, so the conformance tests run against each of them.
+// PostgreSQL is only tested when TEST_POSTGRES_DSN points at a database the tests may write to.
+var testStores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store {
+		return NewMemoryStore()
+	}},
+	{"sqlite", func(t *testing.T) Store {
+		return newTempSQLiteStore(t)
+	}},
+	{"postgres", func(t *testing.T) Store {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("TEST_POSTGRES_DSN is not set")
+		}
+		store, err := NewPostgresStore(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}},
+}
+
+// What a conformance test gets: a store with a valid pricelist of three legs in it.
+// The PostgreSQL database outlives the tests, so every ID is unique to the run.
+type storeFixture struct {
+	store     Store
+	prefix    string
+	pricelist structs.Pricelist
+	now       time.Time
+}
+
+var storeConformance = []struct {
+	name string
+	run  func(t *testing.T, f storeFixture)
+}{
+	{"InsertPricelist", testInsertPricelist},
+	{"RouteGraph and LegProviders", testRouteGraph},
+	{"Route cache", testRouteCache},
+	{"DeletePricelist and PrunePricelists", testDeletePricelist},
+	{"Bookings on a pruned pricelist", testBookingOnPrunedPricelist},
+	{"RecordRejectedPricelist", testRejectedPricelists},
+	{"Bookings filters", testBookingsFilters},
+	{"AddBooking and CancelBooking", testBookingSeats},
+	{"Group bookings", testGroupBookings},
+	{"Seats left and sold out", testSeatsLeft},
+	{"Holds", testHolds},
+	{"Idempotency keys", testIdempotencyKeys},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, backend := range testStores {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, test := range storeConformance {
+				t.Run(test.name, func(t *testing.T) {
+					store := backend.open(t)
+					now := time.Now().UTC().Truncate(time.Second)
+					prefix := fmt.Sprintf("%s-%d", backend.name, time.Now().UnixNano())
+					list := generatePricelist(prefix, 3, 20, now.Add(time.Hour))
+					if err := store.InsertPricelist(list); err != nil {
+						t.Fatal(err)
+					}
+					test.run(t, storeFixture{store: store, prefix: prefix, pricelist: list, now: now})
+				})
+			}
+		})
+	}
+}
+
+// The first provider of the first leg and the first one on the next leg departing after it lands
+func (f storeFixture) connectingProviders(t *testing.T) []string {
+	first := f.pricelist.Legs[0].Providers[0]
+	for _, next := range f.pricelist.Legs[1].Providers {
+		if next.FlightStart.After(first.FlightEnd) {
+			return []string{first.ID, next.ID}
+		}
+	}
+	t.Fatal("no connecting providers in the generated pricelist")
+	return nil
+}
+
+func (f storeFixture) passengers(count int) []structs.Passenger {
+	passengers := make([]structs.Passenger, count)
+	for i := range passengers {
+		passengers[i] = structs.Passenger{FirstName: fmt.Sprintf("Passenger%d", i), LastName: f.prefix}
+	}
+	return passengers
+}
+
+func (f storeFixture) bookedSeats(t *testing.T, providerIDs []string) []int {
+	booked, err := f.store.BookedSeats(f.pricelist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seats := make([]int, len(providerIDs))
+	for i, providerID := range providerIDs {
+		seats[i] = booked[providerID]
+	}
+	return seats
+}
+
+func expectSeats(t *testing.T, f storeFixture, providerIDs []string, want int) {
+	t.Helper()
+	for i, got := range f.bookedSeats(t, providerIDs) {
+		if got != want {
+			t.Fatalf("provider %s has %d seats taken, want %d", providerIDs[i], got, want)
+		}
+	}
+}
+
+func testInsertPricelist(t *testing.T, f storeFixture) {
+	// Storing the same pricelist again does nothing
+	if err := f.store.InsertPricelist(f.pricelist); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := f.store.Pricelist(f.pricelist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.ValidUntil.Equal(f.pricelist.ValidUntil) {
+		t.Fatalf("validUntil is %s, want %s", summary.ValidUntil, f.pricelist.ValidUntil)
+	}
+	if _, err := f.store.Pricelist(f.prefix + "-missing"); !errors.Is(err, ErrNoPricelist) {
+		t.Fatalf("missing pricelist returned %v, want ErrNoPricelist", err)
+	}
+
+	graph, err := f.store.RouteGraph(f.pricelist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leg := range f.pricelist.Legs {
+		from, to := leg.RouteInfo.From.Name, leg.RouteInfo.To.Name
+		if fmt.Sprint(graph[from]) != fmt.Sprint([]string{to}) {
+			t.Fatalf("graph has %v after %s, want [%s]", graph[from], from, to)
+		}
+
+		providers, distance, err := f.store.LegProviders(f.pricelist.ID, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if distance != leg.RouteInfo.Distance || len(providers) != len(leg.Providers) {
+			t.Fatalf("%s to %s has %d providers over %d km, want %d over %d km",
+				from, to, len(providers), distance, len(leg.Providers), leg.RouteInfo.Distance)
+		}
+		for i := 1; i < len(providers); i++ {
+			if providers[i].FlightStart.Before(providers[i-1].FlightStart) {
+				t.Fatalf("%s to %s providers are not sorted by departure", from, to)
+			}
+		}
+	}
+
+	provider := f.pricelist.Legs[1].Providers[0]
+	leg, err := f.store.BookedLeg(f.pricelist.ID, provider.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leg.Price != provider.Price || leg.CompanyName != provider.Company.Name || !leg.FlightStart.Equal(provider.FlightStart) ||
+		leg.From != f.pricelist.Legs[1].RouteInfo.From.Name || leg.To != f.pricelist.Legs[1].RouteInfo.To.Name {
+		t.Fatalf("booked leg is %+v, want provider %+v", leg, provider)
+	}
+	if _, err := f.store.BookedLeg(f.pricelist.ID, f.prefix+"-missing"); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("missing provider returned %v, want ErrUnknownProvider", err)
+	}
+}
+
+func testRouteGraph(t *testing.T, f storeFixture) {
+	// Earth reaches Mars directly and through Venus, and two legs fly from Earth to Mars
+	jumps := jumpsPricelist(f.prefix+"-jumps", f.now.Add(30*time.Minute),
+		[2]string{"Earth", "Mars"}, [2]string{"Venus", "Mars"}, [2]string{"Earth", "Venus"}, [2]string{"Earth", "Mars"})
+	if err := f.store.InsertPricelist(jumps); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := f.store.RouteGraph(jumps.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(graph) != "map[Earth:[Mars Venus] Mars:[] Venus:[Mars]]" {
+		t.Fatalf("graph is %v", graph)
+	}
+
+	providers, distance, err := f.store.LegProviders(jumps.ID, "Earth", "Mars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 || providers[0].ID != jumps.Legs[0].Providers[0].ID || providers[1].ID != jumps.Legs[3].Providers[0].ID {
+		t.Fatalf("Earth to Mars has providers %+v, want those of both legs by departure", providers)
+	}
+	if distance != jumps.Legs[0].RouteInfo.Distance {
+		t.Fatalf("Earth to Mars is %d km, want %d", distance, jumps.Legs[0].RouteInfo.Distance)
+	}
+	want := jumps.Legs[3].Providers[0]
+	if got := providers[1]; got.Price != want.Price || got.CompanyID != want.Company.ID || got.CompanyName != want.Company.Name ||
+		!got.FlightStart.Equal(want.FlightStart) || !got.FlightEnd.Equal(want.FlightEnd) {
+		t.Fatalf("provider is %+v, want %+v", got, want)
+	}
+
+	// Jumps are directed and belong to their own pricelist
+	for _, query := range []struct{ pricelistID, from, destination string }{
+		{jumps.ID, "Mars", "Earth"},
+		{jumps.ID, "Earth", "Jupiter"},
+		{f.pricelist.ID, "Earth", "Mars"},
+		{f.prefix + "-missing", "Earth", "Mars"},
+	} {
+		providers, distance, err := f.store.LegProviders(query.pricelistID, query.from, query.destination)
+		if err != nil || len(providers) != 0 || distance != 0 {
+			t.Fatalf("%s to %s in %s has %d providers over %d km, %v", query.from, query.destination, query.pricelistID, len(providers), distance, err)
+		}
+	}
+	if graph, err := f.store.RouteGraph(f.prefix + "-missing"); err != nil || len(graph) != 0 {
+		t.Fatalf("graph of a missing pricelist is %v, %v", graph, err)
+	}
+}
+
+func testRouteCache(t *testing.T, f storeFixture) {
+	from, to := f.pricelist.Legs[0].RouteInfo.From.Name, f.pricelist.Legs[0].RouteInfo.To.Name
+	if _, ok, err := f.store.CachedRoutes(f.pricelist.ID, from, to); err != nil || ok {
+		t.Fatalf("empty cache returned %v, %v", ok, err)
+	}
+
+	provider := f.pricelist.Legs[0].Providers[0]
+	response := structs.GetResponse{
+		TotalDistance: "1000",
+		ValidUntil:    f.pricelist.ValidUntil.Format(time.RFC3339),
+		PricelistID:   f.pricelist.ID,
+		PossibleRoutes: []structs.PossibleRoute{{
+			Providers: []structs.SimplifiedProvider{{
+				ID:          provider.ID,
+				CompanyName: provider.Company.Name,
+				Price:       provider.Price,
+				FlightStart: provider.FlightStart,
+				FlightEnd:   provider.FlightEnd,
+			}},
+			TotalPrice:      fmt.Sprintf("%.2f", provider.Price),
+			TotalPriceMinor: int64(provider.Price * 100),
+			NumberOfLegs:    1,
+		}},
+		TotalRoutes: 1,
+	}
+	if err := f.store.CacheRoutes(f.pricelist.ID, from, to, response); err != nil {
+		t.Fatal(err)
+	}
+	cached, ok, err := f.store.CachedRoutes(f.pricelist.ID, from, to)
+	if err != nil || !ok {
+		t.Fatalf("cached search returned %v, %v", ok, err)
+	}
+	if fmt.Sprintf("%+v", cached) != fmt.Sprintf("%+v", response) {
+		t.Fatalf("cached response is %+v, want %+v", cached, response)
+	}
+	if _, ok, err := f.store.CachedRoutes(f.pricelist.ID, to, from); err != nil || ok {
+		t.Fatalf("reverse search returned %v, %v", ok, err)
+	}
+
+	// Cleaning keeps only the given pricelist
+	other := generatePricelist(f.prefix+"-other", 1, 1, f.now.Add(30*time.Minute))
+	if err := f.store.InsertPricelist(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.CacheRoutes(other.ID, from, to, response); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.CleanCache(f.pricelist.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := f.store.CachedRoutes(other.ID, from, to); err != nil || ok {
+		t.Fatalf("cache of another pricelist survived cleaning: %v, %v", ok, err)
+	}
+	if _, ok, err := f.store.CachedRoutes(f.pricelist.ID, from, to); err != nil || !ok {
+		t.Fatalf("cache of the kept pricelist returned %v, %v after cleaning", ok, err)
+	}
+}
+
+func testGroupBookings(t *testing.T, f storeFixture) {
+	providerIDs := f.connectingProviders(t)
+	capacity := SeatCapacity{Default: 5}
+	prepare := func(providerIDs []string, passengers []structs.Passenger) structs.Booking {
+		t.Helper()
+		request := structs.Booking{PricelistID: f.pricelist.ID, ProviderIDs: providerIDs, Passengers: passengers}
+		prepared, err := PrepareBooking(f.store, request, f.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prepared
+	}
+
+	// The price of a seat is the same for everyone in the group
+	single := prepare(providerIDs, f.passengers(1))
+	group := prepare(providerIDs, f.passengers(3))
+	var legsPrice float64
+	for _, leg := range group.Legs {
+		legsPrice += leg.Price
+	}
+	if fmt.Sprintf("%.2f", group.PricePerPassenger) != fmt.Sprintf("%.2f", legsPrice) || group.PricePerPassenger != single.PricePerPassenger ||
+		single.TotalPrice != single.PricePerPassenger || fmt.Sprintf("%.2f", group.TotalPrice) != fmt.Sprintf("%.2f", 3*group.PricePerPassenger) {
+		t.Fatalf("a seat costs %.2f alone and %.2f in a group of three paying %.2f, want %.2f a seat",
+			single.TotalPrice, group.PricePerPassenger, group.TotalPrice, legsPrice)
+	}
+
+	// Someone else on the first flight only
+	other, err := f.store.AddBooking(prepare(providerIDs[:1], []structs.Passenger{{FirstName: "Grace", LastName: f.prefix}}), capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	booked, err := f.store.AddBooking(group, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := f.store.Booking(booked.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TotalPrice != group.TotalPrice || stored.PricePerPassenger != group.PricePerPassenger || len(stored.Passengers) != 3 {
+		t.Fatalf("stored group of %d pays %.2f, %.2f a seat, want %.2f", len(stored.Passengers), stored.TotalPrice, stored.PricePerPassenger, group.TotalPrice)
+	}
+	if fmt.Sprint(f.bookedSeats(t, providerIDs)) != "[4 3]" {
+		t.Fatalf("seats are %v after the group booked, want [4 3]", f.bookedSeats(t, providerIDs))
+	}
+
+	// A group that does not fit on every flight takes no seat on any of them
+	if _, err := f.store.AddBooking(prepare(providerIDs, f.passengers(2)), capacity); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("group too large for the first flight returned %v, want ErrSoldOut", err)
+	}
+	if fmt.Sprint(f.bookedSeats(t, providerIDs)) != "[4 3]" {
+		t.Fatalf("refused group left seats %v, want [4 3]", f.bookedSeats(t, providerIDs))
+	}
+
+	// Cancelling gives back the seats of the whole group on every flight, and only theirs
+	if _, err := f.store.CancelBooking(booked.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(f.bookedSeats(t, providerIDs)) != "[1 0]" {
+		t.Fatalf("seats are %v after the group cancelled, want [1 0]", f.bookedSeats(t, providerIDs))
+	}
+	if _, err := f.store.CancelBooking(booked.Reference, f.now); !errors.Is(err, ErrAlreadyCancelled) {
+		t.Fatalf("cancelling the group twice returned %v, want ErrAlreadyCancelled", err)
+	}
+	if fmt.Sprint(f.bookedSeats(t, providerIDs)) != "[1 0]" {
+		t.Fatalf("second cancel changed the seats to %v", f.bookedSeats(t, providerIDs))
+	}
+	if _, err := f.store.CancelBooking(other.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 0)
+}
+
+func testSeatsLeft(t *testing.T, f storeFixture) {
+	// Earth to Mars with the default capacity, then Mars to Jupiter an hour after landing with a company that has more seats
+	list := jumpsPricelist(f.prefix+"-seats", f.now.Add(30*time.Minute),
+		[2]string{"Earth", "Mars"}, [2]string{"Venus", "Jupiter"}, [2]string{"Mars", "Jupiter"})
+	list.Legs[2].Providers[0].Company = structs.Company{ID: f.prefix + "-roomy", Name: "Roomy"}
+	if err := f.store.InsertPricelist(list); err != nil {
+		t.Fatal(err)
+	}
+	capacity := SeatCapacity{Default: 2, Companies: map[string]int{"Roomy": 4}}
+	first, second := list.Legs[0].Providers[0].ID, list.Legs[2].Providers[0].ID
+
+	search := func(seats SeatQuery) []structs.PossibleRoute {
+		t.Helper()
+		var route structs.PossibleRoute
+		for _, jump := range [][2]string{{"Earth", "Mars"}, {"Mars", "Jupiter"}} {
+			providers, _, err := f.store.LegProviders(list.ID, jump[0], jump[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			route.Providers = append(route.Providers, providers...)
+		}
+		routes, err := annotateSeats(f.store, list.ID, []structs.PossibleRoute{route}, seats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return routes
+	}
+	expectSearch := func(seats SeatQuery, want string) {
+		t.Helper()
+		var got []string
+		for _, route := range search(seats) {
+			got = append(got, fmt.Sprintf("%d/%d left %d sold out %v", route.Providers[0].SeatsLeft, route.Providers[1].SeatsLeft, route.SeatsLeft, route.SoldOut))
+		}
+		if fmt.Sprint(got) != want {
+			t.Fatalf("search for %d passengers found %v, want %s", seats.Passengers, got, want)
+		}
+	}
+	book := func(providerIDs []string, passengers int) (structs.Booking, error) {
+		t.Helper()
+		request := structs.Booking{PricelistID: list.ID, ProviderIDs: providerIDs, Passengers: f.passengers(passengers)}
+		prepared, err := PrepareBooking(f.store, request, f.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.store.AddBooking(prepared, capacity)
+	}
+	both := []string{first, second}
+
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 1}, "[2/4 left 2 sold out false]")
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 2}, "[2/4 left 2 sold out false]")
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 3}, "[]")
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 3, IncludeSoldOut: true}, "[2/4 left 2 sold out true]")
+
+	booking, err := book(both, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 1}, "[1/3 left 1 sold out false]")
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 2}, "[]")
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 2, IncludeSoldOut: true}, "[1/3 left 1 sold out true]")
+
+	// Two passengers do not fit in the last seat, and neither flight changes
+	if _, err := book(both, 2); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("two passengers for the last seat returned %v, want ErrSoldOut", err)
+	}
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 1}, "[1/3 left 1 sold out false]")
+	if _, err := book(both, 1); err != nil {
+		t.Fatalf("booking the last seat returned %v", err)
+	}
+	if _, err := book(both, 1); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("booking past the last seat returned %v, want ErrSoldOut", err)
+	}
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 1, IncludeSoldOut: true}, "[0/2 left 0 sold out true]")
+
+	// The company with more seats still has room after the default is used up
+	if _, err := book([]string{second}, 2); err != nil {
+		t.Fatalf("booking the larger company's flight returned %v", err)
+	}
+	if _, err := book([]string{second}, 1); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("booking past the larger company's capacity returned %v, want ErrSoldOut", err)
+	}
+	// A company with fewer seats than are booked shows none left rather than a negative count
+	smaller := SeatCapacity{Default: 10, Companies: map[string]int{"Roomy": 3}}
+	expectSearch(SeatQuery{Capacity: smaller, Passengers: 1, IncludeSoldOut: true}, "[8/0 left 0 sold out true]")
+
+	if _, err := f.store.CancelBooking(booking.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	expectSearch(SeatQuery{Capacity: capacity, Passengers: 1}, "[1/1 left 1 sold out false]")
+}
+
+// A copy of the fixture pricelist with its own legs, routes and providers that shares its
+// planets and companies, as consecutive pricelists from upstream do
+func (f storeFixture) sharingPricelist(id string, validUntil time.Time) structs.Pricelist {
+	list := structs.Pricelist{ID: id, ValidUntil: validUntil}
+	for _, leg := range f.pricelist.Legs {
+		leg.ID = id + "-" + leg.ID
+		leg.RouteInfo.ID = id + "-" + leg.RouteInfo.ID
+		leg.Providers = append([]structs.Provider(nil), leg.Providers...)
+		for i := range leg.Providers {
+			leg.Providers[i].ID = id + "-" + leg.Providers[i].ID
+		}
+		list.Legs = append(list.Legs, leg)
+	}
+	return list
+}
+
+func testDeletePricelist(t *testing.T, f storeFixture) {
+	old := generatePricelist(f.prefix+"-old", 2, 5, f.now.Add(-48*time.Hour))
+	shared := f.sharingPricelist(f.prefix+"-shared", f.now.Add(-47*time.Hour))
+	for _, list := range []structs.Pricelist{old, shared} {
+		if err := f.store.InsertPricelist(list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from, to := old.Legs[0].RouteInfo.From.Name, old.Legs[0].RouteInfo.To.Name
+	if err := f.store.CacheRoutes(old.ID, from, to, structs.GetResponse{PricelistID: old.ID}); err != nil {
+		t.Fatal(err)
+	}
+	provider := old.Legs[0].Providers[0]
+	request := structs.Booking{PricelistID: old.ID, ProviderIDs: []string{provider.ID}, Passengers: f.passengers(1)}
+	prepared, err := PrepareBooking(f.store, request, old.ValidUntil.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking, err := f.store.AddBooking(prepared, SeatCapacity{Default: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only pricelists that expired longer ago than the maximum age go
+	if deleted, err := PrunePricelists(f.store, RetentionPolicy{MaxAge: 72 * time.Hour}, f.now); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.store.Pricelist(old.ID); err != nil {
+		t.Fatalf("pruning deleted %d pricelists including one within the maximum age: %v", deleted, err)
+	}
+	deleted, err := PrunePricelists(f.store, RetentionPolicy{MaxAge: 24 * time.Hour}, f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted < 2 {
+		t.Fatalf("pruning deleted %d pricelists, want at least the two expired ones", deleted)
+	}
+	for _, id := range []string{old.ID, shared.ID} {
+		if _, err := f.store.Pricelist(id); !errors.Is(err, ErrNoPricelist) {
+			t.Fatalf("pruned pricelist %s returned %v, want ErrNoPricelist", id, err)
+		}
+	}
+	if graph, err := f.store.RouteGraph(old.ID); err != nil || len(graph) != 0 {
+		t.Fatalf("graph of a pruned pricelist is %v, %v", graph, err)
+	}
+	if providers, _, err := f.store.LegProviders(old.ID, from, to); err != nil || len(providers) != 0 {
+		t.Fatalf("pruned pricelist still has %d providers, %v", len(providers), err)
+	}
+	if _, err := f.store.BookedLeg(old.ID, provider.ID); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("provider of a pruned pricelist returned %v, want ErrUnknownProvider", err)
+	}
+	if _, ok, err := f.store.CachedRoutes(old.ID, from, to); err != nil || ok {
+		t.Fatalf("cache of a pruned pricelist returned %v, %v", ok, err)
+	}
+	if booked, err := f.store.BookedSeats(old.ID); err != nil || len(booked) != 0 {
+		t.Fatalf("pruned pricelist still has seats %v, %v", booked, err)
+	}
+
+	// Bookings keep what was bought
+	stored, err := f.store.Booking(booking.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Legs) != 1 || stored.Legs[0].ProviderID != provider.ID || stored.Legs[0].Price != provider.Price {
+		t.Fatalf("booking on a pruned pricelist has legs %+v", stored.Legs)
+	}
+
+	// The pricelist that shared planets and companies with the deleted one is untouched
+	if _, err := f.store.Pricelist(f.pricelist.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, leg := range f.pricelist.Legs {
+		providers, distance, err := f.store.LegProviders(f.pricelist.ID, leg.RouteInfo.From.Name, leg.RouteInfo.To.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(providers) != len(leg.Providers) || distance != leg.RouteInfo.Distance {
+			t.Fatalf("kept pricelist has %d providers over %d km after the delete, want %d over %d km",
+				len(providers), distance, len(leg.Providers), leg.RouteInfo.Distance)
+		}
+		for _, provider := range providers {
+			if provider.CompanyName == "" {
+				t.Fatalf("provider %s lost its company", provider.ID)
+			}
+		}
+	}
+}
+
+func testBookingOnPrunedPricelist(t *testing.T, f storeFixture) {
+	old := generatePricelist(f.prefix+"-old", 2, 5, f.now.Add(-48*time.Hour))
+	if err := f.store.InsertPricelist(old); err != nil {
+		t.Fatal(err)
+	}
+	provider := old.Legs[0].Providers[0]
+	capacity := SeatCapacity{Default: 3}
+	request := structs.Booking{PricelistID: old.ID, ProviderIDs: []string{provider.ID}, Passengers: f.passengers(2)}
+	prepared, err := PrepareBooking(f.store, request, old.ValidUntil.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking, err := f.store.AddBooking(prepared, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PrunePricelists(f.store, RetentionPolicy{MaxAge: 24 * time.Hour}, f.now); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := f.store.Booking(booking.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != BookingConfirmed || len(stored.Legs) != 1 || stored.Legs[0].ProviderID != provider.ID || len(stored.Passengers) != 2 {
+		t.Fatalf("booking on a pruned pricelist reads as %+v", stored)
+	}
+	cancelled, err := f.store.CancelBooking(booking.Reference, f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != BookingCancelled {
+		t.Fatalf("cancelled booking is %s", cancelled.Status)
+	}
+	if _, err := f.store.CancelBooking(booking.Reference, f.now); !errors.Is(err, ErrAlreadyCancelled) {
+		t.Fatalf("second cancel returned %v, want ErrAlreadyCancelled", err)
+	}
+
+	// The released seats are not owed to the flights once they are served again
+	if err := f.store.InsertPricelist(old); err != nil {
+		t.Fatal(err)
+	}
+	if booked, err := f.store.BookedSeats(old.ID); err != nil || len(booked) != 0 {
+		t.Fatalf("reinserted pricelist has seats %v, %v", booked, err)
+	}
+	request.Passengers = f.passengers(4)
+	prepared, err = PrepareBooking(f.store, request, old.ValidUntil.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.store.AddBooking(prepared, capacity); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("booking more than the capacity returned %v, want ErrSoldOut", err)
+	}
+}
+
+// The reasons a pricelist was rejected for, read from wherever the store keeps them
+func rejectedReasons(t *testing.T, store Store, pricelistID string) []string {
+	var reasons []string
+	var payloads []string
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.mu.Lock()
+		for _, rejected := range s.rejected {
+			if rejected.pricelistID == pricelistID {
+				reasons = append(reasons, rejected.reason)
+				payloads = append(payloads, string(rejected.payload))
+			}
+		}
+		s.mu.Unlock()
+	case *SQLStore:
+		rows, err := s.query("SELECT Reason, Payload FROM RejectedPricelists WHERE PricelistID = ? ORDER BY ID", pricelistID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var reason, payload string
+			if err := rows.Scan(&reason, &payload); err != nil {
+				t.Fatal(err)
+			}
+			reasons = append(reasons, reason)
+			payloads = append(payloads, payload)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("no way to read the rejected pricelists of a %T", store)
+	}
+
+	for _, payload := range payloads {
+		var list structs.Pricelist
+		if err := json.Unmarshal([]byte(payload), &list); err != nil || list.ID != pricelistID || len(list.Legs) == 0 {
+			t.Fatalf("rejected pricelist was kept as %.100s", payload)
+		}
+	}
+	return reasons
+}
+
+func testRejectedPricelists(t *testing.T, f storeFixture) {
+	rejected := generatePricelist(f.prefix+"-rejected", 1, 2, f.now.Add(-time.Hour))
+	for _, reason := range []string{"expired", "expired", "negative price", "expired"} {
+		if err := f.store.RecordRejectedPricelist(rejected, reason); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reasons := rejectedReasons(t, f.store, rejected.ID); fmt.Sprint(reasons) != "[expired negative price]" {
+		t.Fatalf("rejections kept are %v, want each reason once", reasons)
+	}
+	// Rejected pricelists are not served
+	if _, err := f.store.Pricelist(rejected.ID); !errors.Is(err, ErrNoPricelist) {
+		t.Fatalf("rejected pricelist returned %v, want ErrNoPricelist", err)
+	}
+}
+
+func testBookingsFilters(t *testing.T, f storeFixture) {
+	capacity := SeatCapacity{Default: 10}
+	book := func(providerIDs []string, passengers []structs.Passenger) structs.Booking {
+		t.Helper()
+		request := structs.Booking{PricelistID: f.pricelist.ID, ProviderIDs: providerIDs, Passengers: passengers}
+		prepared, err := PrepareBooking(f.store, request, f.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		booking, err := f.store.AddBooking(prepared, capacity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return booking
+	}
+	// A flight of the leg with a company none of the given bookings use
+	otherCompany := func(leg int, bookings ...structs.Booking) string {
+		t.Helper()
+		for _, provider := range f.pricelist.Legs[leg].Providers {
+			used := false
+			for _, booking := range bookings {
+				used = used || containsString(booking.CompanyNames, provider.Company.Name)
+			}
+			if !used {
+				return provider.ID
+			}
+		}
+		t.Fatal("every company of the leg is used")
+		return ""
+	}
+
+	first := book(f.connectingProviders(t), f.passengers(2))
+	second := book([]string{otherCompany(1, first)}, f.passengers(1))
+	third := book([]string{otherCompany(2, first, second)}, []structs.Passenger{{FirstName: "Grace", LastName: "Hopper" + f.prefix}})
+	if _, err := f.store.CancelBooking(third.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	onDate := func(date string) string {
+		var references []string
+		for _, booking := range []structs.Booking{third, second, first} {
+			if booking.StartTime[:10] == date {
+				references = append(references, booking.Reference)
+			}
+		}
+		return fmt.Sprint(references)
+	}
+
+	// Every filter is combined with the passenger name of the run, as other runs may share the database
+	tests := []struct {
+		name   string
+		filter BookingFilter
+		want   []structs.Booking
+		total  int
+	}{
+		{"passenger", BookingFilter{}, []structs.Booking{third, second, first}, 3},
+		{"passenger in any case", BookingFilter{Passenger: "grace hopper" + f.prefix}, []structs.Booking{third}, 1},
+		{"company", BookingFilter{Company: second.CompanyNames[0]}, []structs.Booking{second}, 1},
+		{"company of a later leg", BookingFilter{Company: first.CompanyNames[1]}, []structs.Booking{first}, 1},
+		{"part of a company name", BookingFilter{Company: "Company"}, nil, 0},
+		{"origin", BookingFilter{From: first.Routes.From}, []structs.Booking{first}, 1},
+		{"destination", BookingFilter{Destination: third.Routes.Destination}, []structs.Booking{third}, 1},
+		{"confirmed", BookingFilter{Status: BookingConfirmed}, []structs.Booking{second, first}, 2},
+		{"cancelled", BookingFilter{Status: BookingCancelled}, []structs.Booking{third}, 1},
+		{"first page", BookingFilter{Limit: 2}, []structs.Booking{third, second}, 3},
+		{"second page", BookingFilter{Limit: 2, Offset: 2}, []structs.Booking{first}, 3},
+		{"past the last page", BookingFilter{Limit: 2, Offset: 3}, nil, 3},
+	}
+	for _, test := range tests {
+		filter := test.filter
+		if filter.Passenger == "" {
+			filter.Passenger = f.prefix
+		}
+		bookings, total, err := f.store.Bookings(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want []string
+		for _, booking := range bookings {
+			got = append(got, booking.Reference)
+		}
+		for _, booking := range test.want {
+			want = append(want, booking.Reference)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) || total != test.total {
+			t.Fatalf("%s: listed %v of %d, want %v of %d", test.name, got, total, want, test.total)
+		}
+	}
+
+	for _, booking := range []structs.Booking{first, second, third} {
+		date := booking.StartTime[:10]
+		bookings, total, err := f.store.Bookings(BookingFilter{Passenger: f.prefix, Date: date})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, booking := range bookings {
+			got = append(got, booking.Reference)
+		}
+		if fmt.Sprint(got) != onDate(date) || total != len(got) {
+			t.Fatalf("bookings on %s are %v of %d, want %s", date, got, total, onDate(date))
+		}
+	}
+}
+
+func testBookingSeats(t *testing.T, f storeFixture) {
+	providerIDs := f.connectingProviders(t)
+	capacity := SeatCapacity{Default: 3}
+	request := structs.Booking{PricelistID: f.pricelist.ID, ProviderIDs: providerIDs, Passengers: f.passengers(2)}
+	prepared, err := PrepareBooking(f.store, request, f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	booking, err := f.store.AddBooking(prepared, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.Reference == "" || booking.Status != BookingConfirmed {
+		t.Fatalf("stored booking has reference %q and status %q", booking.Reference, booking.Status)
+	}
+	expectSeats(t, f, providerIDs, 2)
+
+	stored, err := f.store.Booking(booking.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TotalPrice != prepared.TotalPrice || len(stored.Passengers) != 2 || len(stored.Legs) != 2 ||
+		fmt.Sprint(stored.ProviderIDs) != fmt.Sprint(providerIDs) || stored.Status != BookingConfirmed {
+		t.Fatalf("stored booking is %+v, want %+v", stored, prepared)
+	}
+	bookings, total, err := f.store.Bookings(BookingFilter{Passenger: f.prefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(bookings) != 1 || bookings[0].Reference != booking.Reference {
+		t.Fatalf("listing by passenger found %d bookings, want only %s", total, booking.Reference)
+	}
+
+	// Only one seat is left, so a second booking for two stores nothing
+	if _, err := f.store.AddBooking(prepared, capacity); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("overbooking returned %v, want ErrSoldOut", err)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	// Unless the company has more seats
+	roomier := SeatCapacity{Default: 3, Companies: map[string]int{}}
+	for _, leg := range prepared.Legs {
+		roomier.Companies[leg.CompanyName] = 4
+	}
+	second, err := f.store.AddBooking(prepared, roomier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 4)
+
+	cancelled, err := f.store.CancelBooking(booking.Reference, f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != BookingCancelled || cancelled.CancelledAt == nil || !cancelled.CancelledAt.Equal(f.now) {
+		t.Fatalf("cancelled booking has status %q and cancelledAt %v", cancelled.Status, cancelled.CancelledAt)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	if _, err := f.store.CancelBooking(booking.Reference, f.now); !errors.Is(err, ErrAlreadyCancelled) {
+		t.Fatalf("cancelling twice returned %v, want ErrAlreadyCancelled", err)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	if _, err := f.store.CancelBooking(second.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 0)
+
+	stored, err = f.store.Booking(booking.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != BookingCancelled {
+		t.Fatalf("cancelled booking is stored with status %q", stored.Status)
+	}
+	if _, err := f.store.Booking(f.prefix + "-missing"); !errors.Is(err, ErrUnknownBooking) {
+		t.Fatalf("missing booking returned %v, want ErrUnknownBooking", err)
+	}
+	if _, err := f.store.CancelBooking(f.prefix+"-missing", f.now); !errors.Is(err, ErrUnknownBooking) {
+		t.Fatalf("cancelling a missing booking returned %v, want ErrUnknownBooking", err)
+	}
+}
+
+func testHolds(t *testing.T, f storeFixture) {
+	providerIDs := f.connectingProviders(t)
+	capacity := SeatCapacity{Default: 3}
+	request := structs.Booking{PricelistID: f.pricelist.ID, ProviderIDs: providerIDs, Passengers: make([]structs.Passenger, 2)}
+	prepared, err := PrepareHold(f.store, request, f.now, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hold, err := f.store.AddHold(prepared, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	if _, err := f.store.AddHold(prepared, capacity); !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("overbooking hold returned %v, want ErrSoldOut", err)
+	}
+	stored, err := f.store.Hold(hold.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != HoldActive || !stored.ExpiresAt.Equal(prepared.ExpiresAt) || stored.Booking.TotalPrice != prepared.Booking.TotalPrice {
+		t.Fatalf("stored hold is %+v, want %+v", stored, prepared)
+	}
+
+	// Confirming moves the held seats to the booking instead of taking new ones
+	completed, err := CompleteHold(stored, f.passengers(2), f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	booking, err := f.store.ConfirmHold(hold.Reference, completed, f.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	if _, err := f.store.ConfirmHold(hold.Reference, completed, f.now); !errors.Is(err, ErrHoldConfirmed) {
+		t.Fatalf("confirming twice returned %v, want ErrHoldConfirmed", err)
+	}
+	stored, err = f.store.Hold(hold.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != HoldConfirmed || stored.BookingReference != booking.Reference {
+		t.Fatalf("confirmed hold has status %q and booking %q, want %q", stored.Status, stored.BookingReference, booking.Reference)
+	}
+	if _, err := f.store.CancelBooking(booking.Reference, f.now); err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 0)
+
+	// A hold that runs out gives its seats back and cannot be confirmed anymore
+	expiring, err := f.store.AddHold(prepared, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSeats(t, f, providerIDs, 2)
+	if released, err := f.store.ReleaseExpiredHolds(f.now); err != nil || released != 0 {
+		t.Fatalf("releasing before expiry released %d holds with %v", released, err)
+	}
+	expired := prepared.ExpiresAt.Add(time.Second)
+	if released, err := f.store.ReleaseExpiredHolds(expired); err != nil || released < 1 {
+		t.Fatalf("releasing after expiry released %d holds with %v", released, err)
+	}
+	expectSeats(t, f, providerIDs, 0)
+	stored, err = f.store.Hold(expiring.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != HoldExpired {
+		t.Fatalf("released hold has status %q", stored.Status)
+	}
+	if _, err := f.store.ConfirmHold(expiring.Reference, completed, f.now); !errors.Is(err, ErrHoldExpired) {
+		t.Fatalf("confirming a released hold returned %v, want ErrHoldExpired", err)
+	}
+	if _, err := f.store.Hold(f.prefix + "-missing"); !errors.Is(err, ErrUnknownHold) {
+		t.Fatalf("missing hold returned %v, want ErrUnknownHold", err)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, f storeFixture) {
+	key := f.prefix + "-key"
+	retention := time.Hour
+	lease := time.Minute
+
+	claimed, ok, err := f.store.ClaimIdempotencyKey(key, "first", f.now, retention, lease)
+	if err != nil || !ok || !claimed.InProgress() {
+		t.Fatalf("first claim returned %+v, %v, %v", claimed, ok, err)
+	}
+	earlier, ok, err := f.store.ClaimIdempotencyKey(key, "second", f.now, retention, lease)
+	if err != nil || ok || !earlier.InProgress() || earlier.RequestHash != "first" {
+		t.Fatalf("claim while in progress returned %+v, %v, %v", earlier, ok, err)
+	}
+
+	claimed.StatusCode = 200
+	claimed.ContentType = "application/json"
+	claimed.Response = []byte(`{"reference":"ABC"}`)
+	claimed.Reference = "ABC"
+	if err := f.store.CompleteIdempotencyKey(claimed); err != nil {
+		t.Fatal(err)
+	}
+	earlier, ok, err = f.store.ClaimIdempotencyKey(key, "first", f.now.Add(time.Minute), retention, lease)
+	if err != nil || ok {
+		t.Fatalf("claim after completion returned %v, %v", ok, err)
+	}
+	if earlier.StatusCode != 200 || earlier.ContentType != "application/json" || string(earlier.Response) != string(claimed.Response) ||
+		earlier.Reference != "ABC" || !earlier.CreatedAt.Equal(f.now) {
+		t.Fatalf("completed request is %+v, want %+v", earlier, claimed)
+	}
+
+	// A released key can be claimed again right away
+	released := f.prefix + "-released"
+	claim, ok, err := f.store.ClaimIdempotencyKey(released, "first", f.now, retention, lease)
+	if err != nil || !ok || claim.Token == "" {
+		t.Fatalf("claim returned %+v, %v, %v", claim, ok, err)
+	}
+	// Knowing the key and request is not enough to release it
+	if err := f.store.ReleaseIdempotencyKey(IdempotentRequest{Key: released, RequestHash: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := f.store.ClaimIdempotencyKey(released, "second", f.now, retention, lease); err != nil || ok {
+		t.Fatalf("claim after a release without the token returned %v, %v", ok, err)
+	}
+	if err := f.store.ReleaseIdempotencyKey(claim); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := f.store.ClaimIdempotencyKey(released, "second", f.now, retention, lease); err != nil || !ok {
+		t.Fatalf("claim after release returned %v, %v", ok, err)
+	}
+
+	// A claim left unanswered past the lease is taken over, and the request that lost it can
+	// neither store its response nor release the new claim
+	abandoned := f.prefix + "-abandoned"
+	lost, _, err := f.store.ClaimIdempotencyKey(abandoned, "first", f.now, retention, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := f.store.ClaimIdempotencyKey(abandoned, "second", f.now.Add(lease/2), retention, lease); err != nil || ok {
+		t.Fatalf("claim within the lease returned %v, %v", ok, err)
+	}
+	taken, ok, err := f.store.ClaimIdempotencyKey(abandoned, "second", f.now.Add(2*lease), retention, lease)
+	if err != nil || !ok || taken.RequestHash != "second" {
+		t.Fatalf("claim after the lease returned %+v, %v, %v", taken, ok, err)
+	}
+	lost.StatusCode = 201
+	if err := f.store.CompleteIdempotencyKey(lost); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.ReleaseIdempotencyKey(lost); err != nil {
+		t.Fatal(err)
+	}
+	current, ok, err := f.store.ClaimIdempotencyKey(abandoned, "second", f.now.Add(2*lease), retention, lease)
+	if err != nil || ok || current.RequestHash != "second" || !current.InProgress() {
+		t.Fatalf("claim after the lost request finished returned %+v, %v, %v", current, ok, err)
+	}
+
+	// The same goes for a retry of the same request, which a client sends when the first one hangs
+	retried := f.prefix + "-retried"
+	stale, _, err := f.store.ClaimIdempotencyKey(retried, "first", f.now, retention, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry, ok, err := f.store.ClaimIdempotencyKey(retried, "first", f.now.Add(2*lease), retention, lease)
+	if err != nil || !ok || retry.Token == stale.Token {
+		t.Fatalf("retry after the lease returned %+v, %v, %v", retry, ok, err)
+	}
+	stale.StatusCode = 500
+	stale.Response = []byte(`{"code":"internal_error"}`)
+	if err := f.store.CompleteIdempotencyKey(stale); err != nil {
+		t.Fatal(err)
+	}
+	if current, ok, err := f.store.ClaimIdempotencyKey(retried, "first", f.now.Add(2*lease), retention, lease); err != nil || ok || !current.InProgress() {
+		t.Fatalf("stale request completed the retry's claim: %+v, %v, %v", current, ok, err)
+	}
+	if err := f.store.ReleaseIdempotencyKey(stale); err != nil {
+		t.Fatal(err)
+	}
+	if current, ok, err := f.store.ClaimIdempotencyKey(retried, "first", f.now.Add(2*lease), retention, lease); err != nil || ok || !current.InProgress() {
+		t.Fatalf("stale request released the retry's claim: %+v, %v, %v", current, ok, err)
+	}
+	retry.StatusCode = 200
+	retry.Response = []byte(`{"reference":"DEF"}`)
+	if err := f.store.CompleteIdempotencyKey(retry); err != nil {
+		t.Fatal(err)
+	}
+	if current, ok, err := f.store.ClaimIdempotencyKey(retried, "first", f.now.Add(2*lease), retention, lease); err != nil || ok ||
+		current.StatusCode != 200 || string(current.Response) != `{"reference":"DEF"}` {
+		t.Fatalf("retry stored %+v, %v, %v", current, ok, err)
+	}
+
+	// Answered requests are kept past the lease, until the retention
+	if _, ok, err := f.store.ClaimIdempotencyKey(key, "first", f.now.Add(2*lease), retention, lease); err != nil || ok {
+		t.Fatalf("claim of an answered key after the lease returned %v, %v", ok, err)
+	}
+
+	// Keys older than the retention are forgotten
+	later := f.now.Add(retention + time.Minute)
+	claimed, ok, err = f.store.ClaimIdempotencyKey(key, "third", later, retention, lease)
+	if err != nil || !ok || claimed.RequestHash != "third" {
+		t.Fatalf("claim after the retention returned %+v, %v, %v", claimed, ok, err)
+	}
+}
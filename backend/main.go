@@ -1,190 +1,808 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/cors"
+	"io"
 	"log"
 	"net/http"
-	"os"
-	"slices"
+	"net/url"
+	"space-travel/calculations"
+	"space-travel/config"
 	"space-travel/database"
+	"space-travel/scheduler"
+	"space-travel/sources"
 	"space-travel/structs"
+	"space-travel/validation"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const (
-	tables          = "./database/sql/tables.sql"
-	dbPath          = "./database/pricelists.db"
-	travelPricesURL = "https://cosmos-odyssey.azurewebsites.net/api/v1.0/TravelPrices"
-)
-
-var validPlanets = []string{"Mercury", "Venus", "Earth", "Mars", "Jupiter", "Saturn", "Uranus", "Neptune"}
+// Returned when no valid pricelist is stored after a fetch
+var errExpiredPricelist = errors.New("No valid pricelist available")
 
-func checkLastPricelistValidity(db *sql.DB) (bool, time.Duration) {
-	// Query to get the last entered pricelist
-	query := "SELECT ID, ValidUntil FROM Pricelists ORDER BY ValidUntil DESC LIMIT 1"
-
-	// Execute the query
-	rows, err := db.Query(query)
+func checkLastPricelistValidity(store database.Store) (bool, time.Duration) {
+	latest, err := store.LatestPricelist()
 	if err != nil {
-		log.Println(err)
+		if !errors.Is(err, database.ErrNoPricelist) {
+			log.Println(err)
+		}
 		return false, 0
 	}
-	defer rows.Close()
 
-	if rows.Next() {
-		var pricelistID string
-		var validUntil time.Time
+	if latest.ValidUntil.Before(time.Now()) {
+		return false, 0
+	}
+	return true, time.Until(latest.ValidUntil)
+}
 
-		if err := rows.Scan(&pricelistID, &validUntil); err != nil {
-			log.Println(err)
-			return false, 0
+// Fetch travel prices and store in the database, returning when the latest stored pricelist expires
+func fetchAndStoreTravelPrices(store database.Store, source sources.Source, retention database.RetentionPolicy) (time.Time, error) {
+	list, err := source.Fetch()
+	if errors.Is(err, sources.ErrNotModified) {
+		// Upstream still serves the pricelist stored last time
+		return latestValidUntil(store)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := validation.ValidatePricelist(list, time.Now()); err != nil {
+		if recordErr := store.RecordRejectedPricelist(list, err.Error()); recordErr != nil {
+			log.Println("error: ", recordErr)
 		}
+		return time.Time{}, err
+	}
 
-		if validUntil.Before(time.Now()) {
-			return false, 0
-		} else {
-			durationUntilInvalid := validUntil.Sub(time.Now())
-			return true, durationUntilInvalid
+	if err := store.InsertPricelist(list); err != nil {
+		// Download the same pricelist again next time instead of getting Not Modified
+		if resettable, ok := source.(interface{ Reset() }); ok {
+			resettable.Reset()
 		}
+		return time.Time{}, err
 	}
-	return false, 0
-}
-
-// Fetch travel prices and store in the database
-func fetchAndStoreTravelPrices(db *sql.DB) (error, time.Duration) {
-	valid, duration := checkLastPricelistValidity(db)
-	if valid {
-		return nil, duration
+	if err := store.CleanCache(list.ID); err != nil {
+		return time.Time{}, err
 	}
-	resp, err := http.Get(travelPricesURL)
-	if err != nil {
-		return err, 0
+	// The new pricelist is stored either way, so failing to evict old ones is only logged
+	if _, err := database.PrunePricelists(store, retention, time.Now()); err != nil {
+		log.Println("error: ", err)
 	}
-	defer resp.Body.Close()
+	return latestValidUntil(store)
+}
 
-	var list structs.Pricelist
-	decoder := json.NewDecoder(resp.Body)
-	if err := decoder.Decode(&list); err != nil {
-		return err, 0
+func latestValidUntil(store database.Store) (time.Time, error) {
+	valid, duration := checkLastPricelistValidity(store)
+	if !valid {
+		return time.Time{}, errExpiredPricelist
 	}
+	return time.Now().Add(duration), nil
+}
 
-	if err := database.InsertPricelistData(db, list); err != nil {
-		return err, 0
+// Handle "/api/admin/status" endpoint
+func handleStatusAPI(w http.ResponseWriter, r *http.Request, fetcher *scheduler.Scheduler, cfg config.Config) {
+	if !requireStaff(w, r, cfg) {
+		return
 	}
-	if err := database.CleanCache(db, list.ID); err != nil {
-		return err, 0
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(fetcher.Status()); err != nil {
+		log.Println("error: ", err)
 	}
-	valid, duration = checkLastPricelistValidity(db)
-	return nil, duration
 }
 
 // Handle "/api/get/:from/:destination" endpoint
-func handleGetAPI(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func handleGetAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) {
 	vars := mux.Vars(r)
 	from := vars["from"]
 	destination := vars["destination"]
-	if !checkURLParams(from, destination) {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+	graph, err := database.GetRouteGraph(store)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	if !checkURLParams(graph, from, destination) {
+		writeError(w, http.StatusBadRequest, "invalid_planet", fmt.Sprintf("%q or %q is not a planet of the pricelist", from, destination))
+		return
+	}
+	opts, err := parseSearchOptions(r.URL.Query(), cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	opts.Stops, err = parseStops(r.URL.Query().Get("via"), graph, from, destination)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	// Multi-city searches are not cached
+	if len(opts.Stops) > 0 {
+		opts.Cacheable = false
+	}
+	routeQuery, err := parseRouteQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	seats, err := parseSeatQuery(r.URL.Query(), cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	data, err := database.GetAllPossibleRoutes(store, from, destination, opts, seats)
+	if err != nil {
+		writeDatabaseError(w, err)
 		return
 	}
+	data.PossibleRoutes, data.TotalRoutes = calculations.ApplyRouteQuery(data.PossibleRoutes, routeQuery)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Println("error: ", err)
+	}
+}
+
+// Handle "/api/roundtrip/:from/:destination" endpoint
+func handleRoundTripAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) {
+	vars := mux.Vars(r)
+	values := r.URL.Query()
+	outbound := calculations.Route{From: vars["from"], Destination: vars["destination"]}
+	// The return leg defaults to the reverse of the outbound one
+	inbound := calculations.Route{From: outbound.Destination, Destination: outbound.From}
+	if value := values.Get("returnFrom"); value != "" {
+		inbound.From = value
+	}
+	if value := values.Get("returnTo"); value != "" {
+		inbound.Destination = value
+	}
+
+	graph, err := database.GetRouteGraph(store)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	if !checkURLParams(graph, outbound.From, outbound.Destination) || !checkURLParams(graph, inbound.From, inbound.Destination) {
+		writeError(w, http.StatusBadRequest, "invalid_planet", "A planet of the trip is not in the pricelist")
+		return
+	}
+	opts, err := parseSearchOptions(values, cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	var minStay time.Duration
+	if value := values.Get("minStay"); value != "" {
+		if minStay, err = time.ParseDuration(value); err != nil || minStay < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("invalid minStay: %q", value))
+			return
+		}
+	}
+	routeQuery, err := parseRoundTripPaging(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	seats, err := parseSeatQuery(values, cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	// Only the pairs up to the requested page are built, and no more than MaxRoundTrips of them
+	keep := cfg.MaxRoundTrips
+	if routeQuery.Limit > 0 && routeQuery.Offset < keep-routeQuery.Limit {
+		keep = routeQuery.Offset + routeQuery.Limit
+	}
+	data, err := database.GetRoundTrips(store, outbound, inbound, minStay, opts, seats, keep)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	data.RoundTrips = calculations.PageRoundTrips(data.RoundTrips, routeQuery.Limit, routeQuery.Offset)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println("error: ", err)
+	}
+}
+
+// Parse the search mode and connection overrides of the GET endpoint
+func parseSearchOptions(values url.Values, cfg config.Config) (calculations.SearchOptions, error) {
+	defaults := calculations.ConnectionRule{
+		MinConnection: cfg.MinConnection,
+		MaxLayover:    cfg.MaxLayover,
+	}
+	opts := calculations.SearchOptions{
+		MaxPaths:   cfg.MaxRoutePaths,
+		Connection: defaults,
+	}
+
+	mode, ok := calculations.ParseSearchMode(values.Get("mode"))
+	if !ok {
+		return opts, fmt.Errorf("invalid mode: %q", values.Get("mode"))
+	}
+	opts.Mode = mode
+
+	var err error
+	if value := values.Get("minConnection"); value != "" {
+		if opts.Connection.MinConnection, err = time.ParseDuration(value); err != nil || opts.Connection.MinConnection < 0 {
+			return opts, fmt.Errorf("invalid minConnection: %q", value)
+		}
+	}
+	if value := values.Get("maxLayover"); value != "" {
+		if opts.Connection.MaxLayover, err = time.ParseDuration(value); err != nil || opts.Connection.MaxLayover < 0 {
+			return opts, fmt.Errorf("invalid maxLayover: %q", value)
+		}
+	}
+
+	// Only searches with the server defaults share the route cache
+	opts.Cacheable = opts.Mode == calculations.EarliestArrivalMode && opts.Connection == defaults
+	return opts, nil
+}
+
+// Parse how many seats a search needs
+func parseSeatQuery(values url.Values, cfg config.Config) (database.SeatQuery, error) {
+	seats := database.SeatQuery{Capacity: seatCapacity(cfg), Passengers: 1}
+	var err error
+	if value := values.Get("passengers"); value != "" {
+		if seats.Passengers, err = strconv.Atoi(value); err != nil || seats.Passengers < 1 {
+			return seats, fmt.Errorf("invalid passengers: %q", value)
+		}
+	}
+	if value := values.Get("includeSoldOut"); value != "" {
+		if seats.IncludeSoldOut, err = strconv.ParseBool(value); err != nil {
+			return seats, fmt.Errorf("invalid includeSoldOut: %q", value)
+		}
+	}
+	return seats, nil
+}
+
+func seatCapacity(cfg config.Config) database.SeatCapacity {
+	return database.SeatCapacity{Default: cfg.SeatCapacity, Companies: cfg.CompanySeatCapacity}
+}
+
+// Parse the required stops of a multi-city trip, written as "Jupiter:48h,Mars" where the stay is optional
+func parseStops(value string, graph calculations.Graph, from string, destination string) ([]calculations.Stop, error) {
+	var stops []calculations.Stop
+	previous := from
+	for _, item := range splitList([]string{value}) {
+		planet, stay, hasStay := strings.Cut(item, ":")
+		stop := calculations.Stop{Planet: planet}
+		if !graph.HasPlanet(planet) {
+			return nil, fmt.Errorf("invalid via planet: %q", planet)
+		}
+		if planet == previous {
+			return nil, fmt.Errorf("via planet %q repeats the previous stop", planet)
+		}
+		if hasStay {
+			minStay, err := time.ParseDuration(stay)
+			if err != nil || minStay < 0 {
+				return nil, fmt.Errorf("invalid stay at %s: %q", planet, stay)
+			}
+			stop.MinStay = minStay
+		}
+		stops = append(stops, stop)
+		previous = planet
+	}
+	if len(stops) > 0 && previous == destination {
+		return nil, fmt.Errorf("via planet %q repeats the destination", previous)
+	}
+	return stops, nil
+}
+
+// Parse the sorting, filtering and pagination parameters of the GET endpoint
+func parseRouteQuery(values url.Values) (calculations.RouteQuery, error) {
+	query := calculations.RouteQuery{
+		SortBy:            values.Get("sort"),
+		Companies:         splitList(values["company"]),
+		ExcludedCompanies: splitList(values["excludeCompany"]),
+	}
+	if query.SortBy != "" && !calculations.IsValidSortKey(query.SortBy) {
+		return query, fmt.Errorf("invalid sort: %q", query.SortBy)
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("invalid order: %q", order)
+	}
+
+	var err error
+	if value := values.Get("maxPrice"); value != "" {
+		if query.MaxPrice, err = strconv.ParseFloat(value, 64); err != nil || query.MaxPrice < 0 {
+			return query, fmt.Errorf("invalid maxPrice: %q", value)
+		}
+	}
+	if value := values.Get("maxDuration"); value != "" {
+		if query.MaxDuration, err = time.ParseDuration(value); err != nil || query.MaxDuration < 0 {
+			return query, fmt.Errorf("invalid maxDuration: %q", value)
+		}
+	}
+	if value := values.Get("departAfter"); value != "" {
+		if query.DepartAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("invalid departAfter: %q", value)
+		}
+	}
+	if value := values.Get("departBefore"); value != "" {
+		if query.DepartBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("invalid departBefore: %q", value)
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 0 {
+			return query, fmt.Errorf("invalid limit: %q", value)
+		}
+	}
+	if value := values.Get("offset"); value != "" {
+		if query.Offset, err = strconv.Atoi(value); err != nil || query.Offset < 0 {
+			return query, fmt.Errorf("invalid offset: %q", value)
+		}
+	}
+	return query, nil
+}
+
+// Filters and sorting of the one-way search, which round trips do not support
+var oneWayOnlyParameters = []string{"via", "sort", "order", "company", "excludeCompany", "maxPrice", "maxDuration", "departAfter", "departBefore"}
+
+// Parses limit and offset of the round trip endpoint, refusing the one-way parameters instead of ignoring them
+func parseRoundTripPaging(values url.Values) (calculations.RouteQuery, error) {
+	for _, name := range oneWayOnlyParameters {
+		if values.Has(name) {
+			return calculations.RouteQuery{}, fmt.Errorf("%s is not supported for round trips", name)
+		}
+	}
+	return parseRouteQuery(values)
+}
+
+// Accepts both repeated parameters and comma separated values
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// Handle "/api/post" endpoint, returning the reference of the stored booking
+func handlePostAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) string {
+	var booking structs.Booking
+	err := json.NewDecoder(r.Body).Decode(&booking)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed_json", "Request body is not valid JSON: "+err.Error())
+		return ""
+	}
+	// Everything but the passenger and the chosen providers is taken from the pricelist
+	booking, err = database.PrepareBooking(store, booking, time.Now())
+	if err != nil {
+		writeDatabaseError(w, err)
+		return ""
+	}
+
+	booking, err = store.AddBooking(booking, seatCapacity(cfg))
+	if err != nil {
+		writeDatabaseError(w, err)
+		return ""
+	}
 	w.Header().Set("Content-Type", "application/json")
-	data, err := database.GetAllPossibleRoutes(db, from, destination)
+	if err := json.NewEncoder(w).Encode(booking); err != nil {
+		log.Println("error: ", err)
+	}
+	return booking.Reference
+}
+
+// Idempotency keys longer than this are refused
+const maxIdempotencyKeyLength = 255
+
+// Replays the earlier response to a request repeated with the same Idempotency-Key header, so
+// retries and double clicks do not book twice. The handler returns the reference of what it created.
+func handleIdempotently(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config, handle func(http.ResponseWriter, *http.Request) string) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handle(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, "invalid_idempotency_key", fmt.Sprintf("Idempotency-Key is longer than %d characters", maxIdempotencyKeyLength))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unreadable_body", "Request body could not be read")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	// The same key sent to another endpoint or with another body is a different request
+	hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+	requestHash := hex.EncodeToString(hash[:])
+
+	claim, claimed, err := store.ClaimIdempotencyKey(key, requestHash, time.Now(), cfg.IdempotencyKeyRetention, cfg.IdempotencyKeyLease)
 	if err != nil {
-		if err == database.ErrNoProviders {
-			http.Error(w, "No providers found", http.StatusNotFound)
-		} else {
-			log.Println("error here: ", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeDatabaseError(w, err)
+		return
+	}
+	if !claimed {
+		if claim.RequestHash != requestHash {
+			writeError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+			return
+		}
+		if claim.InProgress() {
+			writeError(w, http.StatusConflict, "request_in_progress", "A request with this Idempotency-Key is still being handled")
+			return
+		}
+		w.Header().Set("Content-Type", claim.ContentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(claim.StatusCode)
+		if _, err := w.Write(claim.Response); err != nil {
+			log.Println("error: ", err)
+		}
+		return
+	}
+
+	// A handler that panics never answers, so its key is released before the panic goes on
+	defer func() {
+		if p := recover(); p != nil {
+			if err := store.ReleaseIdempotencyKey(claim); err != nil {
+				log.Println("error: ", err)
+			}
+			panic(p)
 		}
-	} else {
-		err = json.NewEncoder(w).Encode(data)
-		if err != nil {
+	}()
+
+	recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	reference := handle(recorder, r)
+	// A server error says nothing about the request, so it can be retried with the same key
+	if recorder.statusCode >= http.StatusInternalServerError {
+		if err := store.ReleaseIdempotencyKey(claim); err != nil {
 			log.Println("error: ", err)
 		}
+		return
 	}
+	claim.StatusCode = recorder.statusCode
+	claim.ContentType = recorder.Header().Get("Content-Type")
+	claim.Response = recorder.body.Bytes()
+	claim.Reference = reference
+	if err := store.CompleteIdempotencyKey(claim); err != nil {
+		log.Println("error: ", err)
+	}
+}
+
+// Passes a response on while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
 }
 
-func handlePostAPI(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Body of every error response. Code is stable and meant for programs, Message for people.
+type errorResponse struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details validation.FieldErrors `json:"details,omitempty"`
+}
+
+// Answers a request with an error
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeErrorResponse(w, status, errorResponse{Code: code, Message: message})
+}
+
+// Answers an invalid request with the fields that are wrong
+func writeFieldErrors(w http.ResponseWriter, fieldErrors validation.FieldErrors) {
+	writeErrorResponse(w, http.StatusBadRequest, errorResponse{Code: "validation_failed", Message: "Request has invalid fields", Details: fieldErrors})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, body errorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("error: ", err)
+	}
+}
+
+// Status and code answering each error of the database package
+var databaseErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{database.ErrNoPricelist, http.StatusServiceUnavailable, "no_pricelist"},
+	{database.ErrNoProviders, http.StatusNotFound, "no_providers"},
+	{database.ErrPricelistExpired, http.StatusGone, "pricelist_expired"},
+	{database.ErrSoldOut, http.StatusConflict, "sold_out"},
+	{database.ErrUnknownBooking, http.StatusNotFound, "booking_not_found"},
+	{database.ErrAlreadyCancelled, http.StatusConflict, "booking_cancelled"},
+	{database.ErrUnknownHold, http.StatusNotFound, "hold_not_found"},
+	{database.ErrHoldExpired, http.StatusGone, "hold_expired"},
+	{database.ErrHoldConfirmed, http.StatusConflict, "hold_confirmed"},
+}
+
+// Answers a request that failed with an error from the database package, anything unknown is logged
+// and answered with 500 without details
+func writeDatabaseError(w http.ResponseWriter, err error) {
+	var fieldErrors validation.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		writeFieldErrors(w, fieldErrors)
+		return
+	}
+	for _, known := range databaseErrors {
+		if errors.Is(err, known.err) {
+			writeError(w, known.status, known.code, err.Error())
+			return
+		}
+	}
+	log.Println("error: ", err)
+	writeError(w, http.StatusInternalServerError, "internal_error", "Internal Server Error")
+}
+
+// Handle "/api/holds" endpoint, which keeps the seats and price of an itinerary for a while.
+// It returns the reference of the new hold.
+func handleHoldAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) string {
 	var booking structs.Booking
 	err := json.NewDecoder(r.Body).Decode(&booking)
 	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed_json", "Request body is not valid JSON: "+err.Error())
+		return ""
+	}
+	hold, err := database.PrepareHold(store, booking, time.Now(), cfg.HoldDuration)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return ""
+	}
+
+	hold, err = store.AddHold(hold, seatCapacity(cfg))
+	if err != nil {
+		writeDatabaseError(w, err)
+		return ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hold); err != nil {
+		log.Println("error: ", err)
+	}
+	return hold.Reference
+}
+
+// Handle GET on "/api/holds/:reference" endpoint
+func handleGetHoldAPI(w http.ResponseWriter, r *http.Request, store database.Store) {
+	hold, err := store.Hold(mux.Vars(r)["reference"])
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hold); err != nil {
 		log.Println("error: ", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// Handle "/api/holds/:reference/confirm" endpoint, which books a hold and returns the booking reference
+func handleConfirmHoldAPI(w http.ResponseWriter, r *http.Request, store database.Store) string {
+	reference := mux.Vars(r)["reference"]
+	// Passengers can be named when confirming, a hold made with their names needs no body
+	var request confirmHoldRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "malformed_json", "Request body is not valid JSON: "+err.Error())
+			return ""
+		}
+	}
+
+	booking, err := confirmHold(store, reference, request.Passengers, time.Now())
+	if err != nil {
+		writeDatabaseError(w, err)
+		return ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(booking); err != nil {
+		log.Println("error: ", err)
+	}
+	return booking.Reference
+}
+
+// Books a hold with the passengers named when confirming it
+func confirmHold(store database.Store, reference string, passengers []structs.Passenger, now time.Time) (structs.Booking, error) {
+	hold, err := store.Hold(reference)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+	booking, err := database.CompleteHold(hold, passengers, now)
+	if err != nil {
+		return structs.Booking{}, err
+	}
+	return store.ConfirmHold(reference, booking, now)
+}
+
+// Releases the seats of holds that ran out, until the process ends
+func sweepHolds(store database.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		releaseExpiredHolds(store, time.Now())
+	}
+}
+
+// One pass of the sweep, giving back the seats of the holds that ran out by now
+func releaseExpiredHolds(store database.Store, now time.Time) {
+	released, err := store.ReleaseExpiredHolds(now)
+	if err != nil {
+		log.Println("Failed to release expired holds: ", err)
+		return
+	}
+	if released > 0 {
+		log.Printf("Released %d expired holds", released)
+	}
+}
+
+// Handle "/api/bookings/:reference" endpoint
+func handleBookingAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) {
+	if !requireStaff(w, r, cfg) {
 		return
 	}
-	err = database.AddBooking(db, booking)
+	booking, err := store.Booking(mux.Vars(r)["reference"])
 	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(booking); err != nil {
 		log.Println("error: ", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// Handle DELETE on "/api/bookings/:reference" endpoint
+func handleCancelBookingAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) {
+	if !requireStaff(w, r, cfg) {
+		return
+	}
+	booking, err := store.CancelBooking(mux.Vars(r)["reference"], time.Now())
+	if err != nil {
+		writeDatabaseError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(booking); err != nil {
+		log.Println("error: ", err)
+	}
 }
 
-func checkURLParams(from string, destination string) bool {
-	if slices.Contains(validPlanets, from) == false || slices.Contains(validPlanets, destination) == false {
+// Answers 401 unless the request carries the staff token.
+// Without a configured token nobody gets through.
+func requireStaff(w http.ResponseWriter, r *http.Request, cfg config.Config) bool {
+	if cfg.AdminToken == "" || r.Header.Get("Authorization") != "Bearer "+cfg.AdminToken {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "A valid staff token is needed")
 		return false
 	}
 	return true
 }
 
-func main() {
-	var db *sql.DB
-	// Check if the database file exists
-	if _, err := os.ReadFile(dbPath); err != nil {
-		log.Printf("Database file does not exist. Creating tables...")
-
-		createTableSQL, err := os.ReadFile(tables)
-		if err != nil {
-			log.Fatal(err)
-		}
+// Handle "/api/bookings" endpoint, the booking listing for staff
+func handleBookingsAPI(w http.ResponseWriter, r *http.Request, store database.Store, cfg config.Config) {
+	if !requireStaff(w, r, cfg) {
+		return
+	}
+	filter, err := parseBookingFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	bookings, total, err := store.Bookings(filter)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(structs.BookingList{Bookings: bookings, TotalBookings: total}); err != nil {
+		log.Println("error: ", err)
+	}
+}
 
-		db, err = sql.Open("sqlite3", dbPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
+// Listing pages are capped so a single request cannot read every booking at once
+const (
+	defaultBookingsLimit = 50
+	maxBookingsLimit     = 500
+)
 
-		_, err = db.Exec(string(createTableSQL))
-		if err != nil {
-			log.Fatal(err)
+func parseBookingFilter(query url.Values) (database.BookingFilter, error) {
+	filter := database.BookingFilter{
+		Passenger:   query.Get("passenger"),
+		From:        query.Get("from"),
+		Destination: query.Get("destination"),
+		Company:     query.Get("company"),
+		Date:        query.Get("date"),
+		Status:      query.Get("status"),
+		Limit:       defaultBookingsLimit,
+	}
+	if filter.Date != "" {
+		if _, err := time.Parse("2006-01-02", filter.Date); err != nil {
+			return filter, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", filter.Date)
 		}
-
-		log.Printf("Tables created successfully.")
-	} else {
-		db, err = sql.Open("sqlite3", dbPath)
-		if err != nil {
-			log.Fatal(err)
+	}
+	if filter.Status != "" && filter.Status != database.BookingConfirmed && filter.Status != database.BookingCancelled {
+		return filter, fmt.Errorf("invalid status %q", filter.Status)
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxBookingsLimit {
+			return filter, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxBookingsLimit)
 		}
-		defer db.Close()
+		filter.Limit = limit
 	}
-	go func() {
-		for {
-			err, duration := fetchAndStoreTravelPrices(db)
-			if err != nil {
-				log.Println(err)
-				time.Sleep(time.Minute)
-			} else {
-				time.Sleep(duration)
-			}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset %q", value)
 		}
-	}()
+		filter.Offset = offset
+	}
+	return filter, nil
+}
 
-	router := mux.NewRouter()
-	router.HandleFunc("/api/get/{from}/{destination}", func(w http.ResponseWriter, r *http.Request) {
-		handleGetAPI(w, r, db)
-	}).Methods("GET")
+func checkURLParams(graph calculations.Graph, from string, destination string) bool {
+	if !graph.HasPlanet(from) || !graph.HasPlanet(destination) {
+		return false
+	}
+	return true
+}
 
-	router.HandleFunc("/api/post", func(w http.ResponseWriter, r *http.Request) {
-		handlePostAPI(w, r, db)
-	}).Methods("POST")
+func main() {
+	cfg := config.Load()
+	store, err := database.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
 
+	retention := database.RetentionPolicy{MaxPricelists: cfg.RetentionCount, MaxAge: cfg.RetentionAge}
+	source, err := sources.New(cfg.PricelistSource, cfg.PricelistLocation, cfg.PricelistReplayValidFor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fetcher := scheduler.New(scheduler.Config{
+		InitialBackoff:   cfg.FetchInitialBackoff,
+		MaxBackoff:       cfg.FetchMaxBackoff,
+		Jitter:           cfg.FetchJitter,
+		FailureThreshold: cfg.FetchFailureThreshold,
+		BreakerCooldown:  cfg.FetchBreakerCooldown,
+		RefreshMargin:    cfg.FetchRefreshMargin,
+	}, func() (time.Time, error) {
+		return fetchAndStoreTravelPrices(store, source, retention)
+	})
+	// Fetch right away unless the stored pricelist is still valid
+	var initialDelay time.Duration
+	if valid, duration := checkLastPricelistValidity(store); valid && duration > cfg.FetchRefreshMargin {
+		initialDelay = duration - cfg.FetchRefreshMargin
+	}
+	go fetcher.Run(initialDelay)
+	if cfg.HoldSweepInterval > 0 {
+		go sweepHolds(store, cfg.HoldSweepInterval)
+	}
+
+	router, err := newRouter(store, cfg, fetcher)
+	if err != nil {
+		log.Fatal(err)
+	}
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:8085"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Idempotent-Replayed"},
 		AllowCredentials: true,
 	})
 
@@ -198,3 +816,72 @@ func main() {
 	log.Println("Listening on port " + port)
 	log.Fatal(http.ListenAndServe(":"+port, handler))
 }
+
+// Routes every endpoint of the API to its handler
+func newRouter(store database.Store, cfg config.Config, fetcher *scheduler.Scheduler) (*mux.Router, error) {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "No such endpoint")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not supported by this endpoint")
+	})
+	router.HandleFunc("/api/get/{from}/{destination}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetAPI(w, r, store, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/roundtrip/{from}/{destination}", func(w http.ResponseWriter, r *http.Request) {
+		handleRoundTripAPI(w, r, store, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/admin/status", func(w http.ResponseWriter, r *http.Request) {
+		handleStatusAPI(w, r, fetcher, cfg)
+	}).Methods("GET")
+
+	// The document only changes with the code, so it is encoded once
+	apiDoc, err := json.Marshal(apiDocument())
+	if err != nil {
+		return nil, err
+	}
+	router.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(apiDoc); err != nil {
+			log.Println("error: ", err)
+		}
+	}).Methods("GET")
+
+	router.HandleFunc("/api/post", func(w http.ResponseWriter, r *http.Request) {
+		handleIdempotently(w, r, store, cfg, func(w http.ResponseWriter, r *http.Request) string {
+			return handlePostAPI(w, r, store, cfg)
+		})
+	}).Methods("POST")
+
+	router.HandleFunc("/api/holds", func(w http.ResponseWriter, r *http.Request) {
+		handleIdempotently(w, r, store, cfg, func(w http.ResponseWriter, r *http.Request) string {
+			return handleHoldAPI(w, r, store, cfg)
+		})
+	}).Methods("POST")
+
+	router.HandleFunc("/api/holds/{reference}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetHoldAPI(w, r, store)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/holds/{reference}/confirm", func(w http.ResponseWriter, r *http.Request) {
+		handleIdempotently(w, r, store, cfg, func(w http.ResponseWriter, r *http.Request) string {
+			return handleConfirmHoldAPI(w, r, store)
+		})
+	}).Methods("POST")
+
+	router.HandleFunc("/api/bookings", func(w http.ResponseWriter, r *http.Request) {
+		handleBookingsAPI(w, r, store, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/bookings/{reference}", func(w http.ResponseWriter, r *http.Request) {
+		handleBookingAPI(w, r, store, cfg)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/bookings/{reference}", func(w http.ResponseWriter, r *http.Request) {
+		handleCancelBookingAPI(w, r, store, cfg)
+	}).Methods("DELETE")
+	return router, nil
+}
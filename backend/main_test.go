@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"space-travel/calculations"
+	"space-travel/config"
+	"space-travel/database"
+	"space-travel/scheduler"
+	"space-travel/structs"
+	"space-travel/validation"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	store := database.NewMemoryStore()
+	cfg := config.Config{IdempotencyKeyRetention: time.Hour, IdempotencyKeyLease: time.Minute}
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/post", strings.NewReader(`{"pricelistID":"p"}`))
+		r.Header.Set("Idempotency-Key", "crash")
+		return r
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic of the handler was swallowed")
+			}
+		}()
+		handleIdempotently(httptest.NewRecorder(), request(), store, cfg, func(w http.ResponseWriter, r *http.Request) string {
+			panic("handler crashed")
+		})
+	}()
+
+	// The retry is handled instead of being told the first request is still in progress
+	w := httptest.NewRecorder()
+	handleIdempotently(w, request(), store, cfg, func(w http.ResponseWriter, r *http.Request) string {
+		w.WriteHeader(http.StatusCreated)
+		return ""
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestParseRouteQuery(t *testing.T) {
+	morning := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		want    calculations.RouteQuery
+		wantErr string
+	}{
+		{query: ""},
+		{query: "sort=arrival&order=desc", want: calculations.RouteQuery{SortBy: "arrival", Descending: true}},
+		{query: "sort=price&order=asc", want: calculations.RouteQuery{SortBy: "price"}},
+		{query: "company=Alpha,%20Beta&company=Gamma&excludeCompany=Delta,",
+			want: calculations.RouteQuery{Companies: []string{"Alpha", "Beta", "Gamma"}, ExcludedCompanies: []string{"Delta"}}},
+		{query: "maxPrice=250.5&maxDuration=36h", want: calculations.RouteQuery{MaxPrice: 250.5, MaxDuration: 36 * time.Hour}},
+		{query: "departAfter=2026-01-01T09:00:00Z&departBefore=2026-01-01T11:00:00%2B02:00",
+			want: calculations.RouteQuery{DepartAfter: morning, DepartBefore: morning}},
+		{query: "limit=0&offset=0"},
+		{query: "limit=10&offset=1000", want: calculations.RouteQuery{Limit: 10, Offset: 1000}},
+		{query: "sort=layover", wantErr: `invalid sort: "layover"`},
+		{query: "order=up", wantErr: `invalid order: "up"`},
+		{query: "maxPrice=-1", wantErr: `invalid maxPrice: "-1"`},
+		{query: "maxPrice=cheap", wantErr: `invalid maxPrice: "cheap"`},
+		{query: "maxDuration=-1h", wantErr: `invalid maxDuration: "-1h"`},
+		{query: "maxDuration=36", wantErr: `invalid maxDuration: "36"`},
+		{query: "departAfter=2026-01-01", wantErr: `invalid departAfter: "2026-01-01"`},
+		{query: "departBefore=tomorrow", wantErr: `invalid departBefore: "tomorrow"`},
+		{query: "limit=-1", wantErr: `invalid limit: "-1"`},
+		{query: "limit=ten", wantErr: `invalid limit: "ten"`},
+		{query: "offset=-1", wantErr: `invalid offset: "-1"`},
+		{query: "offset=1.5", wantErr: `invalid offset: "1.5"`},
+	}
+	for _, test := range tests {
+		values, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := parseRouteQuery(values)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("%q returned %v, want %s", test.query, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q returned %v", test.query, err)
+		}
+		if !query.DepartAfter.Equal(test.want.DepartAfter) || !query.DepartBefore.Equal(test.want.DepartBefore) {
+			t.Fatalf("%q departs between %s and %s, want %s and %s", test.query,
+				query.DepartAfter, query.DepartBefore, test.want.DepartAfter, test.want.DepartBefore)
+		}
+		query.DepartAfter, query.DepartBefore = test.want.DepartAfter, test.want.DepartBefore
+		if fmt.Sprintf("%+v", query) != fmt.Sprintf("%+v", test.want) {
+			t.Fatalf("%q parsed to %+v, want %+v", test.query, query, test.want)
+		}
+	}
+}
+
+// A flight from Earth landing on Mars an hour from now, connecting to Jupiter after 30 minutes, 2 hours or 10 hours
+func connectionPricelist(now time.Time) structs.Pricelist {
+	earth := structs.Location{ID: "earth", Name: "Earth"}
+	mars := structs.Location{ID: "mars", Name: "Mars"}
+	jupiter := structs.Location{ID: "jupiter", Name: "Jupiter"}
+	company := structs.Company{ID: "company", Name: "Space Piper"}
+	landing := now.Add(time.Hour)
+	first := structs.Leg{
+		ID:        "first",
+		RouteInfo: structs.RouteInfo{ID: "first-route", From: earth, To: mars, Distance: 1000},
+		Providers: []structs.Provider{{ID: "first-provider", Company: company, Price: 100, FlightStart: now, FlightEnd: landing}},
+	}
+	second := structs.Leg{
+		ID:        "second",
+		RouteInfo: structs.RouteInfo{ID: "second-route", From: mars, To: jupiter, Distance: 1000},
+	}
+	for _, layover := range []time.Duration{30 * time.Minute, 2 * time.Hour, 10 * time.Hour} {
+		second.Providers = append(second.Providers, structs.Provider{
+			ID:          "after-" + layover.String(),
+			Company:     company,
+			Price:       100,
+			FlightStart: landing.Add(layover),
+			FlightEnd:   landing.Add(layover + time.Hour),
+		})
+	}
+	return structs.Pricelist{ID: "pricelist", ValidUntil: now.Add(time.Hour), Legs: []structs.Leg{first, second}}
+}
+
+func TestConnectionOverrides(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	store := database.NewMemoryStore()
+	if err := store.InsertPricelist(connectionPricelist(now)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Load()
+	cfg.MinConnection = time.Hour
+	cfg.MaxLayover = 0
+	router, err := newRouter(store, cfg, scheduler.New(scheduler.Config{}, func() (time.Time, error) {
+		return now.Add(time.Hour), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server defaults come first, so the overrides would see their cached routes if they shared them
+	tests := []struct {
+		query    string
+		layovers string
+	}{
+		{"", "[[7200]]"},
+		{"minConnection=0s", "[[1800]]"},
+		{"minConnection=30m", "[[1800]]"},
+		{"minConnection=30m1s", "[[7200]]"},
+		{"minConnection=2h1s", "[[36000]]"},
+		{"minConnection=10h1s", "[]"},
+		{"minConnection=0s&maxLayover=30m", "[[1800]]"},
+		{"maxLayover=2h", "[[7200]]"},
+		{"maxLayover=2h&minConnection=2h", "[[7200]]"},
+		{"maxLayover=1h59m59s", "[]"},
+		{"", "[[7200]]"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/get/Earth/Jupiter?"+test.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q returned %d: %s", test.query, w.Code, w.Body)
+		}
+		var response structs.GetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		layovers := [][]int64{}
+		for _, route := range response.PossibleRoutes {
+			layovers = append(layovers, route.LayoverSeconds)
+			var total int64
+			for _, layover := range route.LayoverSeconds {
+				total += layover
+			}
+			if route.TotalLayoverSeconds != total {
+				t.Fatalf("%q has layovers %v but a total of %d", test.query, route.LayoverSeconds, route.TotalLayoverSeconds)
+			}
+		}
+		if fmt.Sprint(layovers) != test.layovers {
+			t.Fatalf("%q found layovers %v, want %s", test.query, layovers, test.layovers)
+		}
+	}
+}
+
+func TestSweepReleasesExpiredHolds(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	store := database.NewMemoryStore()
+	if err := store.InsertPricelist(connectionPricelist(now)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Load()
+	cfg.SeatCapacity = 2
+	cfg.CompanySeatCapacity = nil
+	cfg.HoldDuration = time.Hour
+	router, err := newRouter(store, cfg, scheduler.New(scheduler.Config{}, func() (time.Time, error) {
+		return now.Add(time.Hour), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(target string, body string, wantStatus int) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		if w.Code != wantStatus {
+			t.Fatalf("%s answered %d, want %d: %s", target, w.Code, wantStatus, w.Body)
+		}
+		var response map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	providerIDs := []string{"first-provider", "after-2h0m0s"}
+	seats := func() string {
+		t.Helper()
+		booked, err := store.BookedSeats("pricelist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint([]int{booked[providerIDs[0]], booked[providerIDs[1]]})
+	}
+	booking := `{"pricelistID":"pricelist","providerIDs":["first-provider","after-2h0m0s"],"firstName":"Ada","lastName":"Lovelace"}`
+
+	held := post("/api/holds", `{"pricelistID":"pricelist","providerIDs":["first-provider","after-2h0m0s"],"passengers":[{},{}]}`, http.StatusOK)
+	hold := fmt.Sprint(held["reference"])
+	post("/api/post", booking, http.StatusConflict)
+
+	// Nothing is released before the hold runs out
+	releaseExpiredHolds(store, now.Add(cfg.HoldDuration-time.Minute))
+	if seats() != "[2 2]" {
+		t.Fatalf("seats are %s before the hold expired, want [2 2]", seats())
+	}
+
+	releaseExpiredHolds(store, now.Add(cfg.HoldDuration+time.Minute))
+	if seats() != "[0 0]" {
+		t.Fatalf("seats are %s after the sweep, want the held ones back", seats())
+	}
+	stored, err := store.Hold(hold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != database.HoldExpired {
+		t.Fatalf("swept hold has status %q", stored.Status)
+	}
+	// The seats are gone to whoever books next, so the hold cannot be confirmed anymore
+	confirmed := post("/api/holds/"+hold+"/confirm", `{"passengers":[{"firstName":"Ada","lastName":"Lovelace"},{"firstName":"Alan","lastName":"Turing"}]}`, http.StatusGone)
+	if confirmed["code"] != "hold_expired" {
+		t.Fatalf("confirming a swept hold answered %v", confirmed)
+	}
+	if seats() != "[0 0]" {
+		t.Fatalf("refused confirmation left seats %s", seats())
+	}
+	post("/api/post", booking, http.StatusOK)
+	if seats() != "[1 1]" {
+		t.Fatalf("seats are %s after booking the released seats, want [1 1]", seats())
+	}
+}
+
+func TestWriteDatabaseError(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{database.ErrNoPricelist, http.StatusServiceUnavailable, "no_pricelist", database.ErrNoPricelist.Error()},
+		{database.ErrNoProviders, http.StatusNotFound, "no_providers", database.ErrNoProviders.Error()},
+		{database.ErrPricelistExpired, http.StatusGone, "pricelist_expired", database.ErrPricelistExpired.Error()},
+		{database.ErrSoldOut, http.StatusConflict, "sold_out", database.ErrSoldOut.Error()},
+		{database.ErrUnknownBooking, http.StatusNotFound, "booking_not_found", database.ErrUnknownBooking.Error()},
+		{database.ErrAlreadyCancelled, http.StatusConflict, "booking_cancelled", database.ErrAlreadyCancelled.Error()},
+		{database.ErrUnknownHold, http.StatusNotFound, "hold_not_found", database.ErrUnknownHold.Error()},
+		{database.ErrHoldExpired, http.StatusGone, "hold_expired", database.ErrHoldExpired.Error()},
+		{database.ErrHoldConfirmed, http.StatusConflict, "hold_confirmed", database.ErrHoldConfirmed.Error()},
+		// Stores wrap their errors with details, which are passed on
+		{fmt.Errorf("%w on the Space Piper flight from Earth to Mars", database.ErrSoldOut), http.StatusConflict, "sold_out",
+			"Not enough seats left on the Space Piper flight from Earth to Mars"},
+		{validation.FieldErrors{{Field: "pricelistID", Message: "is required"}}, http.StatusBadRequest, "validation_failed", "Request has invalid fields"},
+		// Anything else is not shown to the client
+		{errors.New("connection refused"), http.StatusInternalServerError, "internal_error", "Internal Server Error"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		writeDatabaseError(w, test.err)
+		var body errorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != test.status || body.Code != test.code || body.Message != test.message || w.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("%v answered %d %+v, want %d %s %q", test.err, w.Code, body, test.status, test.code, test.message)
+		}
+	}
+	// A new entry in databaseErrors needs a case here
+	for _, known := range databaseErrors {
+		tested := false
+		for _, test := range tests {
+			tested = tested || test.err == known.err
+		}
+		if !tested {
+			t.Fatalf("%v is answered with %d but not tested", known.err, known.status)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// Config controls when pricelists are fetched
+type Config struct {
+	// Wait after the first failure, doubled for every further one up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Every wait is randomly stretched or shortened by up to this share, 0.2 meaning ±20%.
+	// Waits for the next pricelist are only shortened, so they never run past validUntil.
+	Jitter float64
+	// Consecutive failures that open the circuit breaker, 0 disables it
+	FailureThreshold int
+	// How long the open breaker waits before letting a single trial fetch through
+	BreakerCooldown time.Duration
+	// How long before validUntil the next pricelist is fetched
+	RefreshMargin time.Duration
+}
+
+// FetchFunc fetches and stores a pricelist, returning when the stored one stops being valid
+type FetchFunc func() (time.Time, error)
+
+// Status is a snapshot of the scheduler, served on the admin status endpoint
+type Status struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastAttempt         time.Time `json:"lastAttempt"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	ValidUntil          time.Time `json:"validUntil"`
+	NextAttempt         time.Time `json:"nextAttempt"`
+}
+
+// Scheduler runs fetches with exponential backoff, jitter and a circuit breaker
+type Scheduler struct {
+	cfg   Config
+	fetch FetchFunc
+
+	mu     sync.Mutex
+	status Status
+	rng    *rand.Rand
+	// The clock, replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func New(cfg Config, fetch FetchFunc) *Scheduler {
+	return &Scheduler{
+		cfg:    cfg,
+		fetch:  fetch,
+		status: Status{State: StateClosed},
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Run fetches forever, starting with a fetch after the given delay
+func (s *Scheduler) Run(initialDelay time.Duration) {
+	wait := initialDelay
+	for {
+		s.mu.Lock()
+		s.status.NextAttempt = s.now().Add(wait)
+		s.mu.Unlock()
+
+		s.sleep(wait)
+		wait = s.attempt(s.now())
+	}
+}
+
+// Status returns a copy of the current scheduler state
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Runs one fetch and returns how long to wait before the next one
+func (s *Scheduler) attempt(now time.Time) time.Duration {
+	s.mu.Lock()
+	if s.status.State == StateOpen {
+		s.status.State = StateHalfOpen
+	}
+	s.status.LastAttempt = now
+	s.mu.Unlock()
+
+	validUntil, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		return s.failed(err)
+	}
+	return s.succeeded(validUntil, s.now())
+}
+
+func (s *Scheduler) succeeded(validUntil time.Time, now time.Time) time.Duration {
+	s.status.State = StateClosed
+	s.status.ConsecutiveFailures = 0
+	s.status.LastSuccess = now
+	s.status.LastError = ""
+	s.status.ValidUntil = validUntil
+
+	// Fetch a little before expiry. Inside the margin upstream may still serve the
+	// same pricelist, so then wait for the expiry itself.
+	refreshAt := validUntil.Add(-s.cfg.RefreshMargin)
+	if !refreshAt.After(now) {
+		refreshAt = validUntil
+	}
+	return s.jitterDown(refreshAt.Sub(now))
+}
+
+func (s *Scheduler) failed(err error) time.Duration {
+	s.status.ConsecutiveFailures++
+	s.status.LastError = err.Error()
+	log.Printf("Fetching pricelist failed %d times in a row: %v", s.status.ConsecutiveFailures, err)
+
+	if s.status.State == StateHalfOpen ||
+		(s.cfg.FailureThreshold > 0 && s.status.ConsecutiveFailures >= s.cfg.FailureThreshold) {
+		if s.status.State != StateOpen {
+			log.Printf("Opening the circuit breaker for %s", s.cfg.BreakerCooldown)
+		}
+		s.status.State = StateOpen
+		return s.jitter(s.cfg.BreakerCooldown)
+	}
+
+	exponent := float64(s.status.ConsecutiveFailures - 1)
+	backoff := time.Duration(float64(s.cfg.InitialBackoff) * math.Pow(2, exponent))
+	if backoff > s.cfg.MaxBackoff || backoff <= 0 {
+		backoff = s.cfg.MaxBackoff
+	}
+	return s.jitter(backoff)
+}
+
+func (s *Scheduler) jitter(wait time.Duration) time.Duration {
+	if wait <= 0 {
+		return 0
+	}
+	factor := 1 + s.cfg.Jitter*(2*s.rng.Float64()-1)
+	return time.Duration(float64(wait) * factor)
+}
+
+// Like jitter, but only ever shortens the wait
+func (s *Scheduler) jitterDown(wait time.Duration) time.Duration {
+	if wait <= 0 {
+		return 0
+	}
+	factor := 1 - s.cfg.Jitter*s.rng.Float64()
+	return time.Duration(float64(wait) * factor)
+}
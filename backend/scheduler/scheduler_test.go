@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// A scheduler on a clock that only moves when the test moves it. Fetches take a second and
+// fail while fail is set, otherwise the stored pricelist is valid for validFor.
+type testScheduler struct {
+	*Scheduler
+	clock    time.Time
+	fail     bool
+	validFor time.Duration
+	// State of the breaker while the last fetch ran
+	stateDuringFetch string
+}
+
+func newTestScheduler(cfg Config) *testScheduler {
+	ts := &testScheduler{clock: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), validFor: 10 * time.Minute}
+	ts.Scheduler = New(cfg, func() (time.Time, error) {
+		ts.stateDuringFetch = ts.status.State
+		ts.clock = ts.clock.Add(time.Second)
+		if ts.fail {
+			return time.Time{}, errors.New("upstream is down")
+		}
+		return ts.clock.Add(ts.validFor), nil
+	})
+	ts.rng = rand.New(rand.NewSource(1))
+	ts.now = func() time.Time { return ts.clock }
+	ts.sleep = func(wait time.Duration) { ts.clock = ts.clock.Add(wait) }
+	return ts
+}
+
+// Runs one attempt at the current time and moves the clock to the next one
+func (ts *testScheduler) step() time.Duration {
+	wait := ts.attempt(ts.now())
+	ts.sleep(wait)
+	return wait
+}
+
+func TestBackoffGrowsUpToTheMaximum(t *testing.T) {
+	ts := newTestScheduler(Config{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	ts.fail = true
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if wait := ts.step(); wait != want {
+			t.Fatalf("failure %d waits %s, want %s", i+1, wait, want)
+		}
+		if status := ts.Status(); status.State != StateClosed || status.ConsecutiveFailures != i+1 || status.LastError != "upstream is down" {
+			t.Fatalf("after failure %d the status is %+v", i+1, status)
+		}
+	}
+	// Many failures in a row do not overflow the backoff
+	for i := 0; i < 100; i++ {
+		if wait := ts.step(); wait != 10*time.Second {
+			t.Fatalf("failure %d waits %s, want the maximum", ts.Status().ConsecutiveFailures, wait)
+		}
+	}
+
+	// A success starts over
+	ts.fail = false
+	ts.step()
+	if status := ts.Status(); status.ConsecutiveFailures != 0 || status.LastError != "" || !status.LastSuccess.Equal(ts.clock.Add(-ts.validFor)) {
+		t.Fatalf("after a success the status is %+v", status)
+	}
+	ts.fail = true
+	if wait := ts.step(); wait != time.Second {
+		t.Fatalf("first failure after a success waits %s, want the initial backoff", wait)
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	ts := newTestScheduler(Config{InitialBackoff: time.Minute, MaxBackoff: time.Minute, Jitter: 0.2, RefreshMargin: 30 * time.Second})
+	between := func(name string, waits []time.Duration, min time.Duration, max time.Duration) {
+		t.Helper()
+		lowest, highest := waits[0], waits[0]
+		for _, wait := range waits {
+			if wait < lowest {
+				lowest = wait
+			}
+			if wait > highest {
+				highest = wait
+			}
+		}
+		if lowest < min || highest > max {
+			t.Fatalf("%s waits between %s and %s, want between %s and %s", name, lowest, highest, min, max)
+		}
+		// The whole range is used, not just one end of it
+		if spread := max - min; highest-lowest < spread*8/10 {
+			t.Fatalf("%s waits only between %s and %s", name, lowest, highest)
+		}
+	}
+
+	ts.fail = true
+	var backoffs []time.Duration
+	for i := 0; i < 1000; i++ {
+		backoffs = append(backoffs, ts.step())
+	}
+	between("backoff", backoffs, 48*time.Second, 72*time.Second)
+
+	// The wait for the next pricelist is only ever shortened, so the fetch still happens before validUntil
+	ts.fail = false
+	var refreshes []time.Duration
+	for i := 0; i < 1000; i++ {
+		refreshes = append(refreshes, ts.attempt(ts.now()))
+	}
+	refresh := ts.validFor - 30*time.Second
+	between("refresh", refreshes, refresh*8/10, refresh)
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	ts := newTestScheduler(Config{InitialBackoff: time.Second, MaxBackoff: time.Minute, FailureThreshold: 3, BreakerCooldown: 10 * time.Minute})
+	ts.fail = true
+	ts.step()
+	ts.step()
+	if state := ts.Status().State; state != StateClosed {
+		t.Fatalf("breaker is %s below the threshold", state)
+	}
+	if wait := ts.step(); wait != 10*time.Minute || ts.Status().State != StateOpen {
+		t.Fatalf("failure at the threshold waits %s with the breaker %s, want the cooldown and open", wait, ts.Status().State)
+	}
+
+	// The trial fetch after the cooldown runs half-open, and its failure opens the breaker again right away
+	if wait := ts.step(); wait != 10*time.Minute || ts.stateDuringFetch != StateHalfOpen || ts.Status().State != StateOpen {
+		t.Fatalf("failed trial fetch ran %s and waits %s with the breaker %s", ts.stateDuringFetch, wait, ts.Status().State)
+	}
+
+	// A successful trial closes it
+	ts.fail = false
+	ts.step()
+	if status := ts.Status(); ts.stateDuringFetch != StateHalfOpen || status.State != StateClosed || status.ConsecutiveFailures != 0 {
+		t.Fatalf("successful trial fetch ran %s and left %+v", ts.stateDuringFetch, status)
+	}
+	ts.fail = true
+	if wait := ts.step(); wait != time.Second || ts.Status().State != StateClosed {
+		t.Fatalf("first failure after recovering waits %s with the breaker %s", wait, ts.Status().State)
+	}
+}
+
+func TestRefreshMargin(t *testing.T) {
+	ts := newTestScheduler(Config{RefreshMargin: 30 * time.Second})
+	tests := []struct {
+		validFor time.Duration
+		want     time.Duration
+	}{
+		// Measured from the end of the fetch, which takes a second
+		{10 * time.Minute, 10*time.Minute - 30*time.Second},
+		{30*time.Second + time.Millisecond, time.Millisecond},
+		// Inside the margin upstream may still serve the same pricelist, so the fetch waits for its expiry
+		{30 * time.Second, 30 * time.Second},
+		{10 * time.Second, 10 * time.Second},
+		{0, 0},
+		{-time.Minute, 0},
+	}
+	for _, test := range tests {
+		ts.validFor = test.validFor
+		if wait := ts.step(); wait != test.want {
+			t.Fatalf("pricelist valid for %s waits %s, want %s", test.validFor, wait, test.want)
+		}
+		if status := ts.Status(); !status.ValidUntil.Equal(status.LastSuccess.Add(test.validFor)) {
+			t.Fatalf("status is valid until %s after a pricelist valid for %s", status.ValidUntil, test.validFor)
+		}
+	}
+}
+
+func TestRunSleepsBetweenAttempts(t *testing.T) {
+	ts := newTestScheduler(Config{InitialBackoff: time.Second, MaxBackoff: time.Minute})
+	ts.fail = true
+	waits := make(chan time.Duration)
+	next := make(chan time.Time)
+	ts.sleep = func(wait time.Duration) {
+		next <- ts.Status().NextAttempt
+		waits <- wait
+		ts.clock = ts.clock.Add(wait)
+	}
+	start := ts.clock
+	go ts.Run(5 * time.Second)
+
+	for _, want := range []time.Duration{5 * time.Second, time.Second, 2 * time.Second} {
+		nextAttempt := <-next
+		if wait := <-waits; wait != want {
+			t.Fatalf("Run slept %s, want %s", wait, want)
+		}
+		if !nextAttempt.Equal(start.Add(want)) {
+			t.Fatalf("next attempt is at %s, want %s", nextAttempt, start.Add(want))
+		}
+		// The fetch takes a second
+		start = start.Add(want + time.Second)
+	}
+}
@@ -0,0 +1,209 @@
+package sources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"space-travel/structs"
+	"sync"
+	"time"
+)
+
+// Kinds of pricelist sources that can be selected in the configuration
+const (
+	KindHTTP      = "http"
+	KindFile      = "file"
+	KindDirectory = "dir"
+)
+
+// ErrNotModified is returned when upstream still serves the pricelist fetched last time
+var ErrNotModified = errors.New("Pricelist not modified")
+
+// Source provides the current pricelist from upstream
+type Source interface {
+	Fetch() (structs.Pricelist, error)
+}
+
+// New creates the source of the given kind, location being a URL for http and a path otherwise.
+// Recorded pricelists that already expired are replayed as if valid for replayValidFor from now.
+func New(kind string, location string, replayValidFor time.Duration) (Source, error) {
+	switch kind {
+	case KindHTTP:
+		return &HTTPSource{URL: location, Client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case KindFile:
+		return &FileSource{Path: location, ValidFor: replayValidFor}, nil
+	case KindDirectory:
+		source, err := NewDirectorySource(location)
+		if err != nil {
+			return nil, err
+		}
+		source.ValidFor = replayValidFor
+		return source, nil
+	}
+	return nil, fmt.Errorf("unknown pricelist source: %q", kind)
+}
+
+// HTTPSource fetches the pricelist from a TravelPrices compatible endpoint.
+// Requests are conditional on the ETag and Last-Modified of the previous response.
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+}
+
+func (s *HTTPSource) Fetch() (structs.Pricelist, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+	s.mu.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+	s.mu.Unlock()
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return structs.Pricelist{}, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return structs.Pricelist{}, fmt.Errorf("unexpected status from %s: %s", s.URL, resp.Status)
+	}
+	list, err := decode(resp.Body)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	s.mu.Unlock()
+	return list, nil
+}
+
+// Reset makes the next request unconditional, for when the last pricelist could not be stored
+func (s *HTTPSource) Reset() {
+	s.mu.Lock()
+	s.etag = ""
+	s.lastModified = ""
+	s.mu.Unlock()
+}
+
+// FileSource reads the pricelist from a single JSON file
+type FileSource struct {
+	Path string
+	// How long an expired pricelist is made valid for, 0 keeps the times of the file
+	ValidFor time.Duration
+}
+
+func (s *FileSource) Fetch() (structs.Pricelist, error) {
+	list, err := readFile(s.Path)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+	return replay(list, time.Now(), s.ValidFor), nil
+}
+
+// DirectorySource replays recorded pricelists from a directory in file name order.
+// Once every file has been served the last one keeps being returned.
+type DirectorySource struct {
+	// How long an expired pricelist is made valid for, 0 keeps the times of the files
+	ValidFor time.Duration
+
+	mu    sync.Mutex
+	files []string
+	next  int
+}
+
+func NewDirectorySource(dir string) (*DirectorySource, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no pricelist snapshots in %s", dir)
+	}
+	sort.Strings(files)
+	return &DirectorySource{files: files}, nil
+}
+
+func (s *DirectorySource) Fetch() (structs.Pricelist, error) {
+	s.mu.Lock()
+	file := s.files[s.next]
+	if s.next < len(s.files)-1 {
+		s.next++
+	}
+	s.mu.Unlock()
+
+	list, err := readFile(file)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+	return replay(list, time.Now(), s.ValidFor), nil
+}
+
+// Moves validUntil and every flight of an expired pricelist forward by the same whole minutes,
+// so a recording is stored and booked like the pricelist upstream served back then.
+// Every replay gets new pricelist, leg, route and provider IDs, as stores keep the first
+// pricelist stored under an ID and a later replay would otherwise never replace the first one.
+func replay(list structs.Pricelist, now time.Time, validFor time.Duration) structs.Pricelist {
+	if validFor <= 0 || list.ValidUntil.IsZero() || list.ValidUntil.After(now) {
+		return list
+	}
+	shift := now.Add(validFor).Sub(list.ValidUntil).Round(time.Minute)
+	list.ValidUntil = list.ValidUntil.Add(shift)
+	// Derived from the recorded ID and the new validUntil, so they keep the length of a UUID
+	// and fetching again within the same minute replays the same pricelist
+	replayID := func(id string) string {
+		return uuid.NewSHA1(uuid.NameSpaceOID, []byte(id+"@"+list.ValidUntil.UTC().Format(time.RFC3339))).String()
+	}
+	list.ID = replayID(list.ID)
+	legs := make([]structs.Leg, len(list.Legs))
+	for i, leg := range list.Legs {
+		leg.ID = replayID(leg.ID)
+		leg.RouteInfo.ID = replayID(leg.RouteInfo.ID)
+		leg.Providers = append([]structs.Provider(nil), leg.Providers...)
+		for j := range leg.Providers {
+			leg.Providers[j].ID = replayID(leg.Providers[j].ID)
+			leg.Providers[j].FlightStart = leg.Providers[j].FlightStart.Add(shift)
+			leg.Providers[j].FlightEnd = leg.Providers[j].FlightEnd.Add(shift)
+		}
+		legs[i] = leg
+	}
+	list.Legs = legs
+	return list
+}
+
+func readFile(path string) (structs.Pricelist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return structs.Pricelist{}, err
+	}
+	defer file.Close()
+	return decode(file)
+}
+
+func decode(r io.Reader) (structs.Pricelist, error) {
+	var list structs.Pricelist
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return structs.Pricelist{}, err
+	}
+	return list, nil
+}
@@ -0,0 +1,163 @@
+package sources
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"space-travel/database"
+	"space-travel/structs"
+	"space-travel/validation"
+	"testing"
+	"time"
+)
+
+// Records a pricelist that expired an hour ago, with one flight departing after it expired
+func recordPricelist(t *testing.T, dir string, name string, id string) structs.Pricelist {
+	expired := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	list := structs.Pricelist{
+		ID:         id,
+		ValidUntil: expired,
+		Legs: []structs.Leg{{
+			ID: id + "-leg",
+			RouteInfo: structs.RouteInfo{
+				ID:       id + "-route",
+				From:     structs.Location{ID: "earth", Name: "Earth"},
+				To:       structs.Location{ID: "mars", Name: "Mars"},
+				Distance: 54600000,
+			},
+			Providers: []structs.Provider{{
+				ID:          id + "-provider",
+				Company:     structs.Company{ID: "company", Name: "Space Piper"},
+				Price:       100,
+				FlightStart: expired.Add(time.Hour),
+				FlightEnd:   expired.Add(3 * time.Hour),
+			}},
+		}},
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// Checks that a replay is the recording with every time moved by the same amount and new IDs
+func checkReplay(t *testing.T, recorded structs.Pricelist, replayed structs.Pricelist, now time.Time, validFor time.Duration) {
+	t.Helper()
+	if replayed.ValidUntil.Before(now.Add(validFor-time.Minute)) || replayed.ValidUntil.After(now.Add(validFor+time.Minute)) {
+		t.Fatalf("replay is valid until %s, want about %s", replayed.ValidUntil, now.Add(validFor))
+	}
+	shift := replayed.ValidUntil.Sub(recorded.ValidUntil)
+	if shift%time.Minute != 0 {
+		t.Fatalf("times moved by %s, not by whole minutes", shift)
+	}
+	leg, recordedLeg := replayed.Legs[0], recorded.Legs[0]
+	provider, recordedProvider := leg.Providers[0], recordedLeg.Providers[0]
+	if !provider.FlightStart.Equal(recordedProvider.FlightStart.Add(shift)) || !provider.FlightEnd.Equal(recordedProvider.FlightEnd.Add(shift)) {
+		t.Fatalf("flight moved to %s-%s, want it moved by %s", provider.FlightStart, provider.FlightEnd, shift)
+	}
+	for _, ids := range [][2]string{
+		{replayed.ID, recorded.ID},
+		{leg.ID, recordedLeg.ID},
+		{leg.RouteInfo.ID, recordedLeg.RouteInfo.ID},
+		{provider.ID, recordedProvider.ID},
+	} {
+		if ids[0] == ids[1] || len(ids[0]) != 36 {
+			t.Fatalf("replay has ID %q for %q, want a new UUID", ids[0], ids[1])
+		}
+	}
+	// Planets and companies are shared between pricelists
+	if leg.RouteInfo.From.ID != recordedLeg.RouteInfo.From.ID || provider.Company.ID != recordedProvider.Company.ID {
+		t.Fatal("replay changed the IDs of planets or companies")
+	}
+}
+
+func TestReplayAcrossValidUntil(t *testing.T) {
+	dir := t.TempDir()
+	recorded := recordPricelist(t, dir, "pricelist.json", "recorded")
+	validFor := 15 * time.Minute
+	source := &FileSource{Path: filepath.Join(dir, "pricelist.json"), ValidFor: validFor}
+
+	now := time.Now()
+	first := replay(recorded, now, validFor)
+	checkReplay(t, recorded, first, now, validFor)
+	// Fetching again within the same minute serves the same replay
+	if again := replay(recorded, now, validFor); again.ID != first.ID || again.Legs[0].Providers[0].ID != first.Legs[0].Providers[0].ID {
+		t.Fatalf("replaying again right away gave %s, want %s", again.ID, first.ID)
+	}
+
+	// Once the first replay expired the next one is a different pricelist
+	later := first.ValidUntil.Add(time.Minute)
+	second := replay(recorded, later, validFor)
+	checkReplay(t, recorded, second, later, validFor)
+	if second.ID == first.ID || second.Legs[0].Providers[0].ID == first.Legs[0].Providers[0].ID {
+		t.Fatal("the second replay reuses the IDs of the first one")
+	}
+	if !second.ValidUntil.After(first.ValidUntil) {
+		t.Fatalf("second replay is valid until %s, before the first one", second.ValidUntil)
+	}
+
+	// Both replays are accepted and stored, and the second one is what is served after the first expired
+	store, err := database.NewSQLiteStore(filepath.Join(t.TempDir(), "pricelists.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, fetch := range []struct {
+		list structs.Pricelist
+		at   time.Time
+	}{{first, now}, {second, later}} {
+		if err := validation.ValidatePricelist(fetch.list, fetch.at); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.InsertPricelist(fetch.list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err := store.LatestPricelist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != second.ID || !latest.ValidUntil.After(later) {
+		t.Fatalf("latest stored pricelist is %s valid until %s, want %s valid after %s", latest.ID, latest.ValidUntil, second.ID, later)
+	}
+
+	// The file source replays the recording as of the moment it is fetched
+	fetched, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReplay(t, recorded, fetched, time.Now(), validFor)
+}
+
+func TestReplayKeepsValidPricelists(t *testing.T) {
+	recorded := recordPricelist(t, t.TempDir(), "pricelist.json", "recorded")
+	before := recorded.ValidUntil.Add(-time.Minute)
+	if replayed := replay(recorded, before, 15*time.Minute); replayed.ID != recorded.ID || !replayed.ValidUntil.Equal(recorded.ValidUntil) {
+		t.Fatalf("pricelist that is still valid was replayed as %s valid until %s", replayed.ID, replayed.ValidUntil)
+	}
+	if replayed := replay(recorded, time.Now(), 0); replayed.ID != recorded.ID || !replayed.ValidUntil.Equal(recorded.ValidUntil) {
+		t.Fatal("replay without a validity kept neither the ID nor the times")
+	}
+}
+
+func TestDirectorySourceKeepsServingTheLastFile(t *testing.T) {
+	dir := t.TempDir()
+	first := recordPricelist(t, dir, "1.json", "first")
+	last := recordPricelist(t, dir, "2.json", "last")
+	source, err := NewDirectorySource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.ValidFor = 15 * time.Minute
+	for _, recorded := range []structs.Pricelist{first, last, last} {
+		fetched, err := source.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkReplay(t, recorded, fetched, time.Now(), source.ValidFor)
+	}
+}
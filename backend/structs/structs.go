@@ -41,17 +41,33 @@ type Pricelist struct {
 }
 
 type SimplifiedProvider struct {
+	ID          string    `json:"id"`
 	CompanyName string    `json:"companyName"`
 	CompanyID   string    `json:"companyID"`
 	Price       float64   `json:"price"`
 	FlightStart time.Time `json:"flightStart"`
 	FlightEnd   time.Time `json:"flightEnd"`
+	SeatsLeft   int       `json:"seatsLeft"`
 }
 
 type PossibleRoute struct {
+	Path          []string             `json:"path"`
+	TotalDistance string               `json:"totalDistance"`
 	TotalPrice    string               `json:"totalPrice"`
 	TotalDuration string               `json:"totalDuration"`
 	Providers     []SimplifiedProvider `json:"providers"`
+
+	// Numeric counterparts of the display strings above
+	TotalPriceMinor      int64     `json:"totalPriceMinor"`      // Total price in cents
+	TotalDurationSeconds int64     `json:"totalDurationSeconds"` // From first departure to last arrival
+	TotalLayoverSeconds  int64     `json:"totalLayoverSeconds"`  // Time spent waiting between legs
+	LayoverSeconds       []int64   `json:"layoverSeconds"`       // Wait before each connecting leg
+	FirstDeparture       time.Time `json:"firstDeparture"`
+	LastArrival          time.Time `json:"lastArrival"`
+	NumberOfLegs         int       `json:"numberOfLegs"`
+
+	SeatsLeft int  `json:"seatsLeft"` // Fewest seats left on any leg
+	SoldOut   bool `json:"soldOut"`   // Whether there are fewer seats left than passengers searched for
 }
 
 type GetResponse struct {
@@ -59,21 +75,89 @@ type GetResponse struct {
 	ValidUntil    string `json:"validUntil"`
 	PricelistID   string `json:"pricelistID"`
 	PossibleRoutes []PossibleRoute `json:"possibleRoutes"`
+	TotalRoutes    int             `json:"totalRoutes"`
+}
+
+type RoundTrip struct {
+	Outbound        PossibleRoute `json:"outbound"`
+	Return          PossibleRoute `json:"return"`
+	TotalPrice      string        `json:"totalPrice"`
+	TotalPriceMinor int64         `json:"totalPriceMinor"`
+	StaySeconds     int64         `json:"staySeconds"` // Time between landing and the return departure
+	SeatsLeft       int           `json:"seatsLeft"`
+	SoldOut         bool          `json:"soldOut"`
+}
+
+type RoundTripResponse struct {
+	ValidUntil      string      `json:"validUntil"`
+	PricelistID     string      `json:"pricelistID"`
+	RoundTrips      []RoundTrip `json:"roundTrips"`
+	TotalRoundTrips int         `json:"totalRoundTrips"`
 }
 
 type Booking struct {
-    CompanyNames []string    // Array of company names
-    StartTime   string       // Start time of the flight
-    FirstName   string       // First name of the passenger
-    LastName   string       // Last name of the passenger
-    TotalPrice  float64      // Total price of the booking
-    TotalDuration string         // Total duration of the flight in minutes
-    PricelistID  string        // ID of the pricelist for the booking
-    Routes      Routes       // Route details
-    ValidUntil  string       // Valid until date for the booking
+	Reference         string      `json:"reference"` // Code the booking is looked up by, assigned when stored
+	Status            string      `json:"status"`    // "confirmed" or "cancelled"
+	BookedAt          time.Time   `json:"bookedAt"`
+	CancelledAt       *time.Time  `json:"cancelledAt,omitempty"`
+	ProviderIDs       []string    `json:"providerIDs"`  // Providers booked for each leg, in flight order
+	CompanyNames      []string    `json:"companyNames"` // Array of company names
+	StartTime         string      `json:"startTime"`    // Start time of the flight
+	FirstName         string      `json:"firstName"`    // First name of the lead passenger
+	LastName          string      `json:"lastName"`     // Last name of the lead passenger
+	Passengers        []Passenger `json:"passengers"`   // Everyone travelling, the lead passenger first
+	PricePerPassenger float64     `json:"pricePerPassenger"`
+	TotalPrice        float64     `json:"totalPrice"`    // Total price of the booking for all passengers
+	TotalDuration     string      `json:"totalDuration"` // Total duration of the flight in minutes
+	PricelistID       string      `json:"pricelistID"`   // ID of the pricelist for the booking
+	Routes            Routes      `json:"routes"`        // Route details
+	ValidUntil        string      `json:"validUntil"`    // Valid until date for the booking
+	Legs              []BookedLeg `json:"legs"`          // Flights as they were in the pricelist when booked
+	Return            *ReturnTrip `json:"return"`        // Return direction of a round trip, nil for one-way bookings
+}
+
+type Passenger struct {
+	FirstName   string `json:"firstName"`
+	LastName    string `json:"lastName"`
+	DateOfBirth string `json:"dateOfBirth,omitempty"` // YYYY-MM-DD, optional
+}
+
+type Hold struct {
+	Reference        string    `json:"reference"`
+	Status           string    `json:"status"`    // "held", "confirmed" or "expired"
+	CreatedAt        time.Time `json:"createdAt"`
+	ExpiresAt        time.Time `json:"expiresAt"` // Seats and price are kept until then
+	Booking          Booking   `json:"booking"`   // What is booked when the hold is confirmed
+	BookingReference string    `json:"bookingReference,omitempty"`
+}
+
+type BookingList struct {
+	Bookings      []Booking `json:"bookings"`
+	TotalBookings int       `json:"totalBookings"`
+}
+
+type ReturnTrip struct {
+	ProviderIDs   []string    `json:"providerIDs"`   // Providers booked for each leg, in flight order
+	CompanyNames  []string    `json:"companyNames"`  // Array of company names
+	StartTime     string      `json:"startTime"`     // Start time of the return flight
+	TotalDuration string      `json:"totalDuration"` // Total duration of the return flight
+	Price         float64     `json:"price"`         // Price of the return legs for one passenger
+	Routes        Routes      `json:"routes"`        // Route details
+	Legs          []BookedLeg `json:"legs"`          // Flights as they were in the pricelist when booked
 }
 
 type Routes struct {
-    From    string  // Departure city
-    Destination string // Destination city
+    From    string     `json:"from"`        // Departure city
+    Destination string `json:"destination"` // Destination city
+}
+
+// BookedLeg is one booked flight, copied from the pricelist so the booking outlives it
+type BookedLeg struct {
+	ProviderID  string    `json:"providerID"`
+	CompanyName string    `json:"companyName"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Price       float64   `json:"price"`
+	FlightStart time.Time `json:"flightStart"`
+	FlightEnd   time.Time `json:"flightEnd"`
 }
@@ -0,0 +1,33 @@
+package validation
+
+import "strings"
+
+// FieldError points at one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors lists every invalid field of a request
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Add records a problem with a field
+func (e *FieldErrors) Add(field string, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Err returns the recorded problems as an error, or nil when there are none
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,130 @@
+package validation
+
+import (
+	"fmt"
+	"space-travel/structs"
+	"strings"
+	"time"
+)
+
+// Error lists every problem found in a pricelist
+type Error struct {
+	Reasons []string
+}
+
+func (e *Error) Error() string {
+	return "invalid pricelist: " + strings.Join(e.Reasons, "; ")
+}
+
+// ValidatePricelist checks a pricelist received from upstream before it is stored.
+// It returns an *Error describing every problem, or nil when the pricelist can be used.
+func ValidatePricelist(list structs.Pricelist, now time.Time) error {
+	v := validator{
+		ids:           map[string]string{},
+		locationNames: map[string]string{},
+		companyNames:  map[string]string{},
+	}
+
+	if list.ID == "" {
+		v.fail("pricelist has no ID")
+	}
+	if list.ValidUntil.IsZero() {
+		v.fail("pricelist has no validUntil")
+	} else if !list.ValidUntil.After(now) {
+		v.fail(fmt.Sprintf("pricelist expired at %s", list.ValidUntil.Format(time.RFC3339)))
+	}
+	if len(list.Legs) == 0 {
+		v.fail("pricelist has no legs")
+	}
+
+	for _, leg := range list.Legs {
+		v.checkLeg(leg)
+	}
+
+	if len(v.reasons) > 0 {
+		return &Error{Reasons: v.reasons}
+	}
+	return nil
+}
+
+type validator struct {
+	reasons []string
+	// Leg, route and provider IDs mapped to what they were first seen as
+	ids           map[string]string
+	locationNames map[string]string
+	companyNames  map[string]string
+}
+
+func (v *validator) fail(reason string) {
+	v.reasons = append(v.reasons, reason)
+}
+
+func (v *validator) checkLeg(leg structs.Leg) {
+	where := fmt.Sprintf("leg %s", leg.ID)
+	v.checkUniqueID(leg.ID, "leg")
+	if len(leg.Providers) == 0 {
+		v.fail(where + " has no providers")
+	}
+
+	route := leg.RouteInfo
+	v.checkUniqueID(route.ID, "route")
+	if route.Distance <= 0 {
+		v.fail(fmt.Sprintf("%s has distance %d", where, route.Distance))
+	}
+	v.checkLocation(route.From, where)
+	v.checkLocation(route.To, where)
+	if route.From.ID != "" && route.From.ID == route.To.ID {
+		v.fail(where + " starts and ends at the same location")
+	}
+
+	for _, provider := range leg.Providers {
+		v.checkProvider(provider, where)
+	}
+}
+
+func (v *validator) checkProvider(provider structs.Provider, where string) {
+	v.checkUniqueID(provider.ID, "provider")
+	where = fmt.Sprintf("provider %s on %s", provider.ID, where)
+
+	if provider.Price < 0 {
+		v.fail(fmt.Sprintf("%s has negative price %.2f", where, provider.Price))
+	}
+	if provider.FlightStart.IsZero() || provider.FlightEnd.IsZero() {
+		v.fail(where + " is missing flight times")
+	} else if !provider.FlightEnd.After(provider.FlightStart) {
+		v.fail(where + " lands before it takes off")
+	}
+
+	company := provider.Company
+	if company.ID == "" || company.Name == "" {
+		v.fail(where + " has an unknown company")
+	} else if name, ok := v.companyNames[company.ID]; ok && name != company.Name {
+		v.fail(fmt.Sprintf("company %s is called both %q and %q", company.ID, name, company.Name))
+	} else {
+		v.companyNames[company.ID] = company.Name
+	}
+}
+
+func (v *validator) checkLocation(location structs.Location, where string) {
+	if location.ID == "" || location.Name == "" {
+		v.fail(where + " has an unknown location")
+		return
+	}
+	if name, ok := v.locationNames[location.ID]; ok && name != location.Name {
+		v.fail(fmt.Sprintf("location %s is called both %q and %q", location.ID, name, location.Name))
+		return
+	}
+	v.locationNames[location.ID] = location.Name
+}
+
+func (v *validator) checkUniqueID(id string, kind string) {
+	if id == "" {
+		v.fail(fmt.Sprintf("%s without ID", kind))
+		return
+	}
+	if seen, ok := v.ids[id]; ok {
+		v.fail(fmt.Sprintf("duplicate ID %s used by a %s and a %s", id, seen, kind))
+		return
+	}
+	v.ids[id] = kind
+}